@@ -1,14 +0,0 @@
-register: {
-	// 模板学习
-	username: "admin"
-	password: "admin"
-}
-
-resp: {
-	username: register.username
-	result:   string
-}
-
-test_data: {
-	data: int
-}
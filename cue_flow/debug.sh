@@ -1,5 +0,0 @@
-#!/bin/zsh
-
-
-# shellcheck disable=SC2046
-go run . -c ./tmp.cue
\ No newline at end of file
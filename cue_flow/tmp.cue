@@ -1,14 +0,0 @@
-register: {
-	// 模板学习
-	username: "tester"
-	password: "tester"
-}
-
-confire: {
-	result: register.username
-}
-
-resp: {
-	last_username: confire.result
-	result:   string
-}
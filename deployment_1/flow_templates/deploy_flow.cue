@@ -1,11 +1,7 @@
 package demploy_flow
 
-import (
-	"deployment.com/yamls"
-)
-
-// 引用模板
+// 直接引用 yamls 下的清单，step2 可以像 cue 中定义的对象一样引用 step1，如 step1.metadata.name
 workflow: {
-	step1: yamls.deployment
-	step2: yamls.service
+	step1: _ @manifest("../yamls/deploy.yaml")
+	step2: _ @manifest("../yamls/service.yaml")
 }
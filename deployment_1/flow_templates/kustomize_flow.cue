@@ -0,0 +1,16 @@
+package kustomize_flow
+
+// base/overlay 形式的 kustomization 和 cue 中定义的节点放在同一个工作流中编排
+workflow: {
+	demo: {
+		$task: "kustomize"
+		dir:   "../overlays/demo"
+	}
+	scale: {
+		$task:    "scale"
+		target:   {apiVersion: "apps/v1", kind: "Deployment", name: demo.objects["deployment-demo-flowdeploy"].metadata.name}
+		replicas: 3
+	}
+}
+
+outputs: clusterIP: workflow.demo.live["service-demo-flowsvc"].spec.clusterIP
@@ -6,6 +6,8 @@ import (
 	"cuelang.org/go/cue"
 	"cuelang.org/go/tools/flow"
 	"k8s.io/klog/v2"
+
+	"github.com/penk110/k8s_operator/k8s_client"
 )
 
 const K8sTest1Root = "workflow" // 代表 根节点
@@ -18,7 +20,8 @@ func Handler(v cue.Value) (flow.Runner, error) {
 		return nil, nil
 	}
 
-	// 实际处理的模板的函数体，需要在这里面 调用k8s api 执行资源部署 的逻辑
+	// 每个节点部署自己的资源，等待就绪后把线上的 uid、resourceVersion、status 回填到 cue 中，
+	// 后续节点可以直接引用，如 step2.status.clusterIP
 	return flow.RunnerFunc(func(t *flow.Task) error {
 		fmt.Println("工作流节点", t.Path())
 
@@ -30,11 +33,38 @@ func Handler(v cue.Value) (flow.Runner, error) {
 
 		klog.Infof("k8sJson: %v", string(k8sJson))
 
-		//err = utils.K8sApply(k8sJson, utils.K8sRestConfig, *utils.K8sRestMapper)
-		//if err != nil {
-		//	return err
-		//}
+		// apply 之前先检查是否使用了废弃的 apiVersion
+		apiVersion, _ := t.Value().LookupPath(cue.ParsePath("apiVersion")).String()
+		kind, _ := t.Value().LookupPath(cue.ParsePath("kind")).String()
+		if warning, deprecated := k8s_client.CheckDeprecatedAPI(apiVersion, kind); deprecated {
+			klog.Warningf("task %v: %v", t.Path(), warning)
+			taskWarningsFrom(t.Context()).Add(t.Path().String(), warning)
+		}
+
+		mapper := k8s_client.CachedRestMapper()
+		result, err := k8s_client.Apply(k8sJson, k8s_client.GetConfig(), mapper)
+		if err != nil {
+			klog.Errorf("task %v apply err: %v", t.Path(), err)
+			return err
+		}
+		taskWarningsFrom(t.Context()).Add(t.Path().String(), result.Warnings...)
+
+		live, err := k8s_client.WaitReady(t.Context(), k8s_client.GetConfig(), mapper, result.Object, k8s_client.DefaultReadyTimeout)
+		if err != nil {
+			klog.Errorf("task %v wait ready err: %v", t.Path(), err)
+			return err
+		}
+
+		fill := map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"uid":             string(live.GetUID()),
+				"resourceVersion": live.GetResourceVersion(),
+			},
+		}
+		if status, ok := live.Object["status"]; ok {
+			fill["status"] = status
+		}
 
-		return nil
+		return t.Fill(fill)
 	}), nil
 }
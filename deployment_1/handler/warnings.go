@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"sync"
+
+	"github.com/penk110/k8s_operator/k8s_client"
+)
+
+// maxTaskWarnings 每个节点最多保留的告警数
+const maxTaskWarnings = 20
+
+// TaskWarnings 没有通过 WithTaskWarnings 指定时记录节点告警的位置，key 为节点路径，
+// 多次执行共享，长时间运行的进程应当为每次执行创建 NewTaskWarnings
+var TaskWarnings = NewTaskWarnings()
+
+// TaskWarningRecorder 按节点路径记录告警
+type TaskWarningRecorder struct {
+	mu       sync.Mutex
+	warnings map[string][]k8s_client.Warning
+}
+
+// NewTaskWarnings 一次执行的节点告警
+func NewTaskWarnings() *TaskWarningRecorder {
+	return &TaskWarningRecorder{warnings: map[string][]k8s_client.Warning{}}
+}
+
+type taskWarningsKey struct{}
+
+// WithTaskWarnings 传给 flow.Controller.Run 的 ctx，Handler 把节点告警记录到 tw
+func WithTaskWarnings(ctx context.Context, tw *TaskWarningRecorder) context.Context {
+	return context.WithValue(ctx, taskWarningsKey{}, tw)
+}
+
+// taskWarningsFrom ctx 对应的告警记录，没有时为 TaskWarnings
+func taskWarningsFrom(ctx context.Context) *TaskWarningRecorder {
+	if tw, ok := ctx.Value(taskWarningsKey{}).(*TaskWarningRecorder); ok {
+		return tw
+	}
+	return TaskWarnings
+}
+
+// Add 追加节点的告警，超过 maxTaskWarnings 时丢弃最早的
+func (tw *TaskWarningRecorder) Add(path string, warnings ...k8s_client.Warning) {
+	if len(warnings) == 0 {
+		return
+	}
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	all := append(tw.warnings[path], warnings...)
+	if len(all) > maxTaskWarnings {
+		all = append([]k8s_client.Warning(nil), all[len(all)-maxTaskWarnings:]...)
+	}
+	tw.warnings[path] = all
+}
+
+func (tw *TaskWarningRecorder) Get(path string) []k8s_client.Warning {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	return append([]k8s_client.Warning(nil), tw.warnings[path]...)
+}
+
+// All 返回所有节点的告警
+func (tw *TaskWarningRecorder) All() map[string][]k8s_client.Warning {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	all := make(map[string][]k8s_client.Warning, len(tw.warnings))
+	for path, warnings := range tw.warnings {
+		all[path] = append([]k8s_client.Warning(nil), warnings...)
+	}
+	return all
+}
+
+// Reset 清空所有节点的告警
+func (tw *TaskWarningRecorder) Reset() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.warnings = map[string][]k8s_client.Warning{}
+}
@@ -1,15 +1,30 @@
 package k8s_client
 
 import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/cli-runtime/pkg/resource"
 	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/util"
 )
 
+// Result 单次操作的结果，附带 apiserver 返回的告警
+type Result struct {
+	Object   *unstructured.Unstructured
+	Warnings []Warning
+}
+
 func NewRestClient(restConfig *rest.Config, gv schema.GroupVersion) (rest.Interface, error) {
+	if restConfig.WarningHandler == nil {
+		restConfig.WarningHandler = ClientSetWarnings
+	}
 	restConfig.ContentConfig = resource.UnstructuredPlusDefaultContentConfig()
 	restConfig.GroupVersion = &gv
 
@@ -22,9 +37,89 @@ func NewRestClient(restConfig *rest.Config, gv schema.GroupVersion) (rest.Interf
 	return rest.RESTClientFor(restConfig)
 }
 
-func Apply(jsonData []byte, restConfig *rest.Config, mapper meta.RESTMapper) error {
+// Apply 和 kubectl apply 一致：对象不存在时创建，存在时基于 last-applied 注解做三路合并 patch
+func Apply(jsonData []byte, restConfig *rest.Config, mapper meta.RESTMapper) (*Result, error) {
+	result, err := apply(jsonData, restConfig, mapper, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Object: result.Object, Warnings: result.Warnings}, nil
+}
+
+// DryRunResult 服务端 dry-run 的结果，Live 为空表示对象不存在，apply 时会创建
+type DryRunResult struct {
+	Live     *unstructured.Unstructured
+	Object   *unstructured.Unstructured
+	Warnings []Warning
+}
 
-	return nil
+// DryRunApply 和 Apply 的逻辑一致，但是以服务端 dry-run 的方式提交，返回线上对象和 apply 之后的对象
+func DryRunApply(jsonData []byte, restConfig *rest.Config, mapper meta.RESTMapper) (*DryRunResult, error) {
+	return apply(jsonData, restConfig, mapper, true)
+}
+
+func apply(jsonData []byte, restConfig *rest.Config, mapper meta.RESTMapper, dryRun bool) (*DryRunResult, error) {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(jsonData); err != nil {
+		return nil, err
+	}
+	if obj.GetName() == "" {
+		return nil, fmt.Errorf("apply %s: metadata.name is required", obj.GroupVersionKind().String())
+	}
+
+	recorder := NewWarningRecorder()
+	helper, mapping, err := newResourceHelper(restConfig, mapper, obj.GroupVersionKind(), recorder)
+	if err != nil {
+		return nil, err
+	}
+	setDefaultNamespaceIfScopedAndNoneSet(obj, helper)
+	helper = helper.DryRun(dryRun)
+	result := &DryRunResult{}
+	namespace, name := obj.GetNamespace(), obj.GetName()
+
+	modified, err := util.GetModifiedConfiguration(obj, true, unstructured.UnstructuredJSONScheme)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied runtime.Object
+	current, err := helper.Get(namespace, name)
+	switch {
+	case errors.IsNotFound(err):
+		if err = util.CreateApplyAnnotation(obj, unstructured.UnstructuredJSONScheme); err != nil {
+			return nil, err
+		}
+		applied, err = helper.Create(namespace, true, obj)
+		if err != nil {
+			return nil, err
+		}
+		if !dryRun {
+			klog.Infof("%s %s/%s created", mapping.Resource.Resource, namespace, name)
+		}
+	case err != nil:
+		return nil, err
+	default:
+		if result.Live, err = toUnstructured(current); err != nil {
+			return nil, err
+		}
+		patcher, err := NewPatcher(&resource.Info{Mapping: mapping}, helper)
+		if err != nil {
+			return nil, err
+		}
+		_, applied, err = patcher.Patch(current, modified, namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		if !dryRun {
+			klog.Infof("%s %s/%s configured", mapping.Resource.Resource, namespace, name)
+		}
+	}
+
+	if result.Object, err = toUnstructured(applied); err != nil {
+		return nil, err
+	}
+	result.Warnings = recorder.Warnings()
+	return result, nil
 }
 
 func Describe(restConfig *rest.Config, gvk schema.GroupVersionKind, namespace, name string) (string, error) {
@@ -38,17 +133,91 @@ func Get(jsonData string, restConfig *rest.Config, mapper meta.RESTMapper) ([]*m
 
 }
 
+// Delete 删除 jsonData 描述的对象，只需要 apiVersion、kind、metadata.name/namespace，
+// 默认前台级联删除
 func Delete(jsonData string, restConfig *rest.Config, mapper meta.RESTMapper) error {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON([]byte(jsonData)); err != nil {
+		return err
+	}
+
+	helper, mapping, err := newResourceHelper(restConfig, mapper, obj.GroupVersionKind(), nil)
+	if err != nil {
+		return err
+	}
+	setDefaultNamespaceIfScopedAndNoneSet(obj, helper)
+
+	options := asDeleteOptions(true, -1)
+	if _, err = helper.DeleteWithOptions(obj.GetNamespace(), obj.GetName(), &options); err != nil {
+		return err
+	}
+	klog.Infof("%s %s/%s deleted", mapping.Resource.Resource, obj.GetNamespace(), obj.GetName())
 
 	return nil
 }
 
+// GetObject 获取单个对象
+func GetObject(restConfig *rest.Config, mapper meta.RESTMapper, gvk schema.GroupVersionKind, namespace, name string) (*Result, error) {
+	recorder := NewWarningRecorder()
+	helper, _, err := newResourceHelper(restConfig, mapper, gvk, recorder)
+	if err != nil {
+		return nil, err
+	}
+	if helper.NamespaceScoped && namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	obj, err := helper.Get(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	u, err := toUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{Object: u, Warnings: recorder.Warnings()}, nil
+}
+
+// newResourceHelper 根据 gvk 构造对应资源的 resource.Helper，该 helper 收到的告警记录到 recorder
+// restConfig 会被拷贝，NewRestClient 会修改传入的配置，避免污染全局配置
+func newResourceHelper(restConfig *rest.Config, mapper meta.RESTMapper, gvk schema.GroupVersionKind, recorder *WarningRecorder) (*resource.Helper, *meta.RESTMapping, error) {
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	restConfig = rest.CopyConfig(restConfig)
+	if recorder != nil {
+		restConfig.WarningHandler = recorder
+	}
+	restClient, err := NewRestClient(restConfig, mapping.GroupVersionKind.GroupVersion())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resource.NewHelper(restClient, mapping), mapping, nil
+}
+
+// toUnstructured helper 返回的对象统一转成 unstructured
+func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u, nil
+	}
+
+	objMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: objMap}, nil
+}
+
 func setDefaultNamespaceIfScopedAndNoneSet(unstructured *unstructured.Unstructured, helper *resource.Helper) {
 	namespace := unstructured.GetNamespace()
 
 	if helper.NamespaceScoped && namespace == "" {
 
-		namespace = "default"
+		namespace = DefaultNamespace
 		unstructured.SetNamespace(namespace)
 	}
 }
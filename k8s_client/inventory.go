@@ -0,0 +1,211 @@
+package k8s_client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+const (
+	defaultInventoryConcurrency = 8
+	defaultInventoryPageSize    = 500
+)
+
+// InventoryOptions 资源盘点参数
+type InventoryOptions struct {
+	// Namespace 不为空时只盘点该命名空间下的命名空间级资源
+	Namespace     string
+	LabelSelector string
+
+	// Concurrency 同时 list 的资源类型数量
+	Concurrency int
+	// PageSize 每个资源类型分页 list 的每页数量
+	PageSize int64
+
+	// SummaryOnly 只统计数量，不保留对象
+	SummaryOnly bool
+}
+
+// InventoryCount 单个 gvk 的统计
+type InventoryCount struct {
+	GroupVersionKind schema.GroupVersionKind `json:"groupVersionKind"`
+	Resource         string                  `json:"resource"`
+	Namespaced       bool                    `json:"namespaced"`
+	Count            int                     `json:"count"`
+}
+
+// InventoryFailure 无法盘点的 api group 或资源类型，如无权限、聚合 apiserver 不可用
+type InventoryFailure struct {
+	GroupVersion string `json:"groupVersion"`
+	Resource     string `json:"resource,omitempty"`
+	Reason       string `json:"reason"`
+	Message      string `json:"message"`
+}
+
+// InventoryResult 盘点结果，部分资源失败不影响其他资源的结果
+type InventoryResult struct {
+	Objects  map[schema.GroupVersionKind][]unstructured.Unstructured `json:"-"`
+	Summary  []InventoryCount                                        `json:"summary"`
+	Failures []InventoryFailure                                      `json:"failures,omitempty"`
+	Warnings []Warning                                               `json:"warnings,omitempty"`
+}
+
+// Total 所有 gvk 的对象总数
+func (r *InventoryResult) Total() int {
+	total := 0
+	for _, count := range r.Summary {
+		total += count.Count
+	}
+	return total
+}
+
+type inventoryTarget struct {
+	gvk        schema.GroupVersionKind
+	resource   string
+	namespaced bool
+}
+
+// Inventory 通过 discovery 获取所有可 list 的资源类型，并发盘点集群（或指定命名空间）中的全部对象
+func Inventory(restConfig *rest.Config, opts InventoryOptions) (*InventoryResult, error) {
+	// discovery 和 list 使用同一个 restConfig，盘点其他集群时不能用默认的 ClientSet
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	apiGroupResources, err := restmapper.GetAPIGroupResources(discoveryClient)
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDiscoveryRESTMapper(apiGroupResources)
+
+	result := &InventoryResult{
+		Objects: map[schema.GroupVersionKind][]unstructured.Unstructured{},
+	}
+
+	var targets []inventoryTarget
+	for _, group := range apiGroupResources {
+		// 同一个 group 只盘点首选版本，避免重复统计
+		version := group.Group.PreferredVersion
+		resources, ok := group.VersionedResources[version.Version]
+		if !ok {
+			// 聚合 apiserver 不可用时 discovery 只返回 group，没有资源列表
+			result.Failures = append(result.Failures, InventoryFailure{
+				GroupVersion: version.GroupVersion,
+				Reason:       "Unavailable",
+				Message:      "discovery failed for group version",
+			})
+			continue
+		}
+
+		gv, err := schema.ParseGroupVersion(version.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range resources {
+			// 跳过子资源和不支持 list 的资源
+			if strings.Contains(r.Name, "/") || !sets.NewString(r.Verbs...).Has("list") {
+				continue
+			}
+			if opts.Namespace != "" && !r.Namespaced {
+				continue
+			}
+			targets = append(targets, inventoryTarget{
+				gvk:        gv.WithKind(r.Kind),
+				resource:   r.Name,
+				namespaced: r.Namespaced,
+			})
+		}
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultInventoryConcurrency
+	}
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultInventoryPageSize
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+	for _, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(target inventoryTarget) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			var (
+				items    []unstructured.Unstructured
+				count    int
+				warnings []Warning
+			)
+			listOptions := ListOptions{
+				Namespace:     opts.Namespace,
+				AllNamespaces: opts.Namespace == "",
+				LabelSelector: opts.LabelSelector,
+				Limit:         pageSize,
+			}
+			err := ListAll(restConfig, mapper, target.gvk, listOptions, func(page *ListResult) bool {
+				count += len(page.Items)
+				if !opts.SummaryOnly {
+					items = append(items, page.Items...)
+				}
+				warnings = append(warnings, page.Warnings...)
+				return true
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			result.Warnings = append(result.Warnings, warnings...)
+			if err != nil {
+				reason := string(apierrors.ReasonForError(err))
+				if reason == "" {
+					reason = "Unknown"
+				}
+				result.Failures = append(result.Failures, InventoryFailure{
+					GroupVersion: target.gvk.GroupVersion().String(),
+					Resource:     target.resource,
+					Reason:       reason,
+					Message:      err.Error(),
+				})
+				return
+			}
+			result.Summary = append(result.Summary, InventoryCount{
+				GroupVersionKind: target.gvk,
+				Resource:         target.resource,
+				Namespaced:       target.namespaced,
+				Count:            count,
+			})
+			if len(items) > 0 {
+				result.Objects[target.gvk] = items
+			}
+		}(target)
+	}
+	wg.Wait()
+
+	sort.Slice(result.Summary, func(i, j int) bool {
+		return result.Summary[i].GroupVersionKind.String() < result.Summary[j].GroupVersionKind.String()
+	})
+	sort.Slice(result.Failures, func(i, j int) bool {
+		return fmt.Sprintf("%s/%s", result.Failures[i].GroupVersion, result.Failures[i].Resource) <
+			fmt.Sprintf("%s/%s", result.Failures[j].GroupVersion, result.Failures[j].Resource)
+	})
+
+	return result, nil
+}
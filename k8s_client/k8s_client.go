@@ -7,15 +7,20 @@ import (
 	"log"
 	"net"
 	"os"
+	"sync"
 	"time"
 
 	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 	"k8s.io/metrics/pkg/client/clientset/versioned"
 )
@@ -30,10 +35,68 @@ const (
 var ClientSet *kubernetes.Clientset
 var LocalClientSet *kubernetes.Clientset
 var MetricClientSet *versioned.Clientset
+var DynamicClient dynamic.Interface
 var config *rest.Config
 
-func init() {
+// DefaultNamespace 对象没有指定 namespace 时使用的命名空间，k8sflow 通过 -n 或 kubeconfig 的 context 修改
+var DefaultNamespace = "default"
+
+// initOnce 第一次使用时才连接集群，没有调用 Init 时使用测试用的配置
+var initOnce sync.Once
+
+// Init 使用指定的配置初始化各个 client，需要在第一次使用之前调用，如 k8sflow 通过 --kubeconfig 加载的配置
+func Init(cfg *rest.Config) error {
 	var err error
+	initOnce.Do(func() {
+		err = setConfig(cfg)
+	})
+	return err
+}
+
+// LoadKubeconfig 加载 kubeconfig，path 为空时按 KUBECONFIG 环境变量和 ~/.kube/config 查找，
+// contextName 为空时使用当前 context，返回配置和 context 中的 namespace
+func LoadKubeconfig(path, contextName string) (*rest.Config, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if path != "" {
+		rules.ExplicitPath = path
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: contextName}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+
+	cfg, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+	cfg.WarningHandler = ClientSetWarnings
+	return cfg, namespace, nil
+}
+
+func setConfig(cfg *rest.Config) error {
+	var err error
+	config = cfg
+	if ClientSet, err = kubernetes.NewForConfig(config); err != nil {
+		return fmt.Errorf("new clientset failed, err: %s", err.Error())
+	}
+	if MetricClientSet, err = versioned.NewForConfig(config); err != nil {
+		return fmt.Errorf("new metric clientset failed, err: %s", err.Error())
+	}
+	if DynamicClient, err = dynamic.NewForConfig(config); err != nil {
+		return fmt.Errorf("new dynamic client failed, err: %s", err.Error())
+	}
+	LocalClientSet = ClientSet
+	return nil
+}
+
+// ensure 没有调用 Init 时使用测试用的配置初始化
+func ensure() {
+	initOnce.Do(initDefault)
+}
+
+func initDefault() {
 	// 当前机器
 	host := "https://127.0.0.1:6443"
 
@@ -61,7 +124,7 @@ func init() {
 		CertData: certData,
 		KeyData:  keyData,
 	}
-	config = &rest.Config{
+	cfg := &rest.Config{
 		Host:                host,
 		APIPath:             "",
 		ContentConfig:       rest.ContentConfig{},
@@ -75,11 +138,10 @@ func init() {
 		ExecProvider:        nil,
 		TLSClientConfig:     tlsConfig,
 		Timeout:             time.Second * 300,
+		WarningHandler:      ClientSetWarnings,
 	}
-
-	ClientSet, err = kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Fatalf("new clientset failed, err: %s", err.Error())
+	if err := setConfig(cfg); err != nil {
+		log.Fatal(err)
 	}
 
 	// TEST connect
@@ -87,28 +149,30 @@ func init() {
 	listOption := v1.ListOptions{}
 
 	nodeInterface := ClientSet.CoreV1().Nodes()
-	_, err = nodeInterface.List(ctx, listOption)
+	_, err := nodeInterface.List(ctx, listOption)
 	if err != nil {
 		log.Fatalf("list node failed, err: %s", err.Error())
 	}
-	MetricClientSet, err = versioned.NewForConfig(config)
-	if err != nil {
-		log.Fatalf("new metric clientset failed, err: %s", err.Error())
-	}
-	LocalClientSet = ClientSet
 }
 
 func GetConfig() *rest.Config {
+	ensure()
 	return config
 }
 
 func GetClientSet() *kubernetes.Clientset {
+	ensure()
 	return ClientSet
 }
 
+func GetDynamicClient() dynamic.Interface {
+	ensure()
+	return DynamicClient
+}
+
 func RestMapper() (meta.RESTMapper, error) {
 	// 动态获取所有的api group resources
-	apiGroupResources, err := restmapper.GetAPIGroupResources(ClientSet.Discovery())
+	apiGroupResources, err := restmapper.GetAPIGroupResources(GetClientSet().Discovery())
 	if err != nil {
 		return nil, err
 	}
@@ -117,8 +181,23 @@ func RestMapper() (meta.RESTMapper, error) {
 	return mapper, nil
 }
 
+var (
+	cachedRestMapper     meta.RESTMapper
+	cachedRestMapperOnce sync.Once
+)
+
+// CachedRestMapper 基于内存缓存 discovery 的 mapper，找不到资源时会自动刷新缓存，
+// 适合在整个进程内共用
+func CachedRestMapper() meta.RESTMapper {
+	cachedRestMapperOnce.Do(func() {
+		discoveryClient := memory.NewMemCacheClient(GetClientSet().Discovery())
+		cachedRestMapper = restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient)
+	})
+	return cachedRestMapper
+}
+
 func InitWatch() (informers.SharedInformerFactory, error) {
-	factory := informers.NewSharedInformerFactory(ClientSet, 0)
+	factory := informers.NewSharedInformerFactory(GetClientSet(), 0)
 
 	handler, err := factory.Core().V1().
 		Namespaces().
@@ -133,3 +212,8 @@ func InitWatch() (informers.SharedInformerFactory, error) {
 
 	return factory, nil
 }
+
+// InitDynamicWatch 自定义资源使用的 informer 工厂，namespace 为空时监听所有命名空间
+func InitDynamicWatch(namespace string, resync time.Duration) dynamicinformer.DynamicSharedInformerFactory {
+	return dynamicinformer.NewFilteredDynamicSharedInformerFactory(GetDynamicClient(), resync, namespace, nil)
+}
@@ -0,0 +1,172 @@
+package k8s_client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/rest"
+)
+
+// ListOutput 列表结果的输出形式
+type ListOutput string
+
+const (
+	ListOutputUnstructured ListOutput = "unstructured"
+	ListOutputTable        ListOutput = "table"
+	ListOutputName         ListOutput = "name"
+)
+
+// 以 Table 形式获取列表时使用的 Accept 头，和 kubectl get 保持一致
+const tableAcceptHeader = "application/json;as=Table;v=v1;g=meta.k8s.io,application/json"
+
+// ListOptions 列表查询参数
+type ListOptions struct {
+	// Namespace 为空且资源是命名空间级别时使用 default，AllNamespaces 为 true 时忽略
+	Namespace     string
+	AllNamespaces bool
+
+	LabelSelector string
+	FieldSelector string
+
+	// Limit 每页数量，0 表示不分页
+	Limit int64
+	// Continue 上一页返回的 continue token
+	Continue string
+
+	Output ListOutput
+}
+
+// ListResult 一页列表结果，根据 Output 只填充 Items、Table、Names 中的一个
+type ListResult struct {
+	Items []unstructured.Unstructured
+	Table *metav1.Table
+	Names []string
+
+	// Continue 不为空表示还有下一页
+	Continue           string
+	RemainingItemCount *int64
+	ResourceVersion    string
+
+	Warnings []Warning
+}
+
+// List 查询任意 gvk 的一页数据，支持 label/field selector 和 continue token 分页
+func List(restConfig *rest.Config, mapper meta.RESTMapper, gvk schema.GroupVersionKind, opts ListOptions) (*ListResult, error) {
+	recorder := NewWarningRecorder()
+	helper, mapping, err := newResourceHelper(restConfig, mapper, gvk, recorder)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := opts.Namespace
+	if opts.AllNamespaces || !helper.NamespaceScoped {
+		namespace = ""
+	} else if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	listOptions := &metav1.ListOptions{
+		LabelSelector: opts.LabelSelector,
+		FieldSelector: opts.FieldSelector,
+		Limit:         opts.Limit,
+		Continue:      opts.Continue,
+	}
+
+	if opts.Output == ListOutputTable {
+		result, err := listTable(helper, namespace, listOptions)
+		if err != nil {
+			return nil, err
+		}
+		result.Warnings = recorder.Warnings()
+		return result, nil
+	}
+
+	obj, err := helper.List(namespace, mapping.GroupVersionKind.GroupVersion().String(), listOptions)
+	if err != nil {
+		return nil, resource.EnhanceListError(err, *listOptions, mapping.Resource.String())
+	}
+
+	list, ok := obj.(*unstructured.UnstructuredList)
+	if !ok {
+		return nil, fmt.Errorf("unexpected list type %T", obj)
+	}
+
+	result := &ListResult{
+		Continue:           list.GetContinue(),
+		RemainingItemCount: list.GetRemainingItemCount(),
+		ResourceVersion:    list.GetResourceVersion(),
+		Warnings:           recorder.Warnings(),
+	}
+
+	switch opts.Output {
+	case ListOutputName:
+		for _, item := range list.Items {
+			result.Names = append(result.Names, resourceName(mapping, item.GetName()))
+		}
+	case ListOutputUnstructured, "":
+		result.Items = list.Items
+	default:
+		return nil, fmt.Errorf("unsupported list output %q", opts.Output)
+	}
+
+	return result, nil
+}
+
+// ListAll 从 opts.Continue 开始逐页拉取，每一页回调一次 fn，fn 返回 false 停止翻页
+func ListAll(restConfig *rest.Config, mapper meta.RESTMapper, gvk schema.GroupVersionKind, opts ListOptions, fn func(page *ListResult) bool) error {
+	for {
+		page, err := List(restConfig, mapper, gvk, opts)
+		if err != nil {
+			return err
+		}
+		if !fn(page) || page.Continue == "" {
+			return nil
+		}
+		opts.Continue = page.Continue
+	}
+}
+
+func listTable(helper *resource.Helper, namespace string, listOptions *metav1.ListOptions) (*ListResult, error) {
+	raw, err := helper.RESTClient.Get().
+		NamespaceIfScoped(namespace, helper.NamespaceScoped).
+		Resource(helper.Resource).
+		VersionedParams(listOptions, metav1.ParameterCodec).
+		SetHeader("Accept", tableAcceptHeader).
+		Do(context.TODO()).
+		Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	table := &metav1.Table{}
+	if err = json.Unmarshal(raw, table); err != nil {
+		return nil, err
+	}
+	// 老版本 apiserver 不支持 Table 时会直接返回列表
+	if table.Kind != "Table" {
+		return nil, fmt.Errorf("server does not support table output for %s", helper.Resource)
+	}
+
+	return &ListResult{
+		Table:              table,
+		Continue:           table.Continue,
+		RemainingItemCount: table.RemainingItemCount,
+		ResourceVersion:    table.ResourceVersion,
+	}, nil
+}
+
+// resourceName 和 kubectl get -o name 输出格式一致，如 deployment.apps/nginx
+func resourceName(mapping *meta.RESTMapping, name string) string {
+	kind := strings.ToLower(mapping.GroupVersionKind.Kind)
+	if group := mapping.GroupVersionKind.Group; group != "" {
+		kind = kind + "." + group
+	}
+	return kind + "/" + name
+}
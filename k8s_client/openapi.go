@@ -0,0 +1,172 @@
+package k8s_client
+
+import (
+	"compress/gzip"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	openapi_v2 "github.com/google/gnostic-models/openapiv2"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/util/proto/validation"
+	"k8s.io/kubectl/pkg/util/openapi"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// SchemaCluster 使用集群 /openapi/v2 返回的 schema
+	SchemaCluster = "cluster"
+	// DefaultSchemaVersion 缺省使用的内置 schema 版本
+	DefaultSchemaVersion = "v1.27"
+)
+
+// 内置的 schema 只保留 definitions，去掉了 description，每个版本一个文件
+//
+//go:embed openapi/*.json.gz
+var bundledOpenAPI embed.FS
+
+// SchemaVersions 内置 schema 的 Kubernetes 版本
+func SchemaVersions() []string {
+	entries, _ := bundledOpenAPI.ReadDir("openapi")
+	var versions []string
+	for _, e := range entries {
+		versions = append(versions, strings.TrimSuffix(e.Name(), ".json.gz"))
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// LoadSchemas 加载校验对象用的 OpenAPI v2 schema。
+// source 为 cluster 时读取集群的 schema，为内置版本号如 v1.27 时使用内置的 schema，否则当作 swagger.json 文件路径
+func LoadSchemas(source string) (openapi.Resources, error) {
+	if source == SchemaCluster {
+		doc, err := GetClientSet().Discovery().OpenAPISchema()
+		if err != nil {
+			return nil, fmt.Errorf("get cluster openapi schema: %v", err)
+		}
+		return openapi.NewOpenAPIData(doc)
+	}
+
+	data, err := readBundledSchema(source)
+	if os.IsNotExist(err) {
+		if data, err = os.ReadFile(source); os.IsNotExist(err) {
+			return nil, fmt.Errorf("unknown schema %q, use %s, a swagger.json file or one of %s",
+				source, SchemaCluster, strings.Join(SchemaVersions(), ", "))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc, err := openapi_v2.ParseDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse openapi schema %s: %v", source, err)
+	}
+	return openapi.NewOpenAPIData(doc)
+}
+
+func readBundledSchema(version string) ([]byte, error) {
+	f, err := bundledOpenAPI.Open(path.Join("openapi", version+".json.gz"))
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// SchemaError 对象不满足 schema 的一处错误，Path 为对象内的字段路径，如 spec.template.spec.containers[0].containerPorts
+type SchemaError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e SchemaError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidateObject 按 apiVersion、kind 查找 schema 校验对象，检查未知字段、类型错误和缺少的必填字段。
+// 找不到 schema 时（如集群中没有的 CRD）返回 false
+func ValidateObject(resources openapi.Resources, obj map[string]interface{}) ([]SchemaError, bool) {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil || kind == "" {
+		return nil, false
+	}
+	model := resources.LookupResource(gv.WithKind(kind))
+	if model == nil {
+		return nil, false
+	}
+
+	// 统一成 yaml 解码的类型，整数为 int64，和 kubectl 的客户端校验一致
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return []SchemaError{{Message: err.Error()}}, true
+	}
+	var normalized interface{}
+	if err = yaml.Unmarshal(data, &normalized); err != nil {
+		return []SchemaError{{Message: err.Error()}}, true
+	}
+
+	var errs []SchemaError
+	for _, err := range validation.ValidateModel(normalized, model, "") {
+		errs = append(errs, schemaError(err))
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs, true
+}
+
+func schemaError(err error) SchemaError {
+	ve, ok := err.(validation.ValidationError)
+	if !ok {
+		return SchemaError{Message: err.Error()}
+	}
+	base := strings.TrimPrefix(ve.Path, ".")
+	switch e := ve.Err.(type) {
+	case validation.UnknownFieldError:
+		return SchemaError{Path: joinFieldPath(base, e.Field), Message: fmt.Sprintf("unknown field %q in %s", e.Field, e.Path)}
+	case validation.MissingRequiredFieldError:
+		return SchemaError{Path: joinFieldPath(base, e.Field), Message: fmt.Sprintf("missing required field %q in %s", e.Field, e.Path)}
+	case validation.InvalidTypeError:
+		return SchemaError{Path: base, Message: fmt.Sprintf("invalid type: got %q, expected %q", e.Actual, e.Expected)}
+	default:
+		return SchemaError{Path: base, Message: ve.Err.Error()}
+	}
+}
+
+func joinFieldPath(base, field string) string {
+	if base == "" {
+		return field
+	}
+	return base + "." + field
+}
+
+var fieldPathToken = regexp.MustCompile(`\[(\d+)\]|[^.\[]+`)
+
+// SplitFieldPath 把 SchemaError.Path 拆成字段名和下标，下标为 int
+func SplitFieldPath(p string) []interface{} {
+	var parts []interface{}
+	for _, m := range fieldPathToken.FindAllStringSubmatch(p, -1) {
+		if m[1] != "" {
+			i, _ := strconv.Atoi(m[1])
+			parts = append(parts, i)
+			continue
+		}
+		parts = append(parts, m[0])
+	}
+	return parts
+}
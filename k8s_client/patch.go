@@ -0,0 +1,117 @@
+package k8s_client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/rest"
+)
+
+// DefaultFieldManager server-side apply 默认的 field manager
+const DefaultFieldManager = "k8s-operator"
+
+// PatchOptions 手写 patch 的参数
+type PatchOptions struct {
+	// PatchType 支持 JSONPatchType、MergePatchType、StrategicMergePatchType、ApplyPatchType
+	PatchType types.PatchType
+	Patch     []byte
+
+	// If set, forces the patch against a specific resourceVersion
+	ResourceVersion *string
+
+	// 仅 ApplyPatchType 使用
+	FieldManager string
+	Force        bool
+}
+
+// Patch 对已存在的对象执行一次手写的 patch，用于只修改一个注解、镜像之类的场景，
+// 不需要拿到完整的 manifest 重新 apply
+func Patch(restConfig *rest.Config, mapper meta.RESTMapper, gvk schema.GroupVersionKind, namespace, name string, opts PatchOptions) (*Result, error) {
+	if name == "" {
+		return nil, fmt.Errorf("patch %s: name is required", gvk.String())
+	}
+
+	recorder := NewWarningRecorder()
+	helper, _, err := newResourceHelper(restConfig, mapper, gvk, recorder)
+	if err != nil {
+		return nil, err
+	}
+	if helper.NamespaceScoped && namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	patch, err := preparePatch(opts)
+	if err != nil {
+		return nil, fmt.Errorf("patch %s %s/%s: %v", gvk.Kind, namespace, name, err)
+	}
+
+	patchOptions := &metav1.PatchOptions{}
+	if opts.PatchType == types.ApplyPatchType {
+		fieldManager := opts.FieldManager
+		if fieldManager == "" {
+			fieldManager = DefaultFieldManager
+		}
+		helper = helper.WithFieldManager(fieldManager)
+		if opts.Force {
+			force := true
+			patchOptions.Force = &force
+		}
+	}
+
+	obj, err := helper.Patch(namespace, name, opts.PatchType, patch, patchOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := toUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{Object: u, Warnings: recorder.Warnings()}, nil
+}
+
+// preparePatch 校验 patch 内容，并按需写入 resourceVersion 作为前置条件
+func preparePatch(opts PatchOptions) ([]byte, error) {
+	if len(opts.Patch) == 0 {
+		return nil, fmt.Errorf("empty patch")
+	}
+
+	switch opts.PatchType {
+	case types.JSONPatchType:
+		var ops []map[string]interface{}
+		if err := json.Unmarshal(opts.Patch, &ops); err != nil {
+			return nil, fmt.Errorf("invalid json patch: %v", err)
+		}
+		if opts.ResourceVersion == nil {
+			return opts.Patch, nil
+		}
+		// json patch 是操作列表，无法直接写入 metadata，在最前面加一个 test 操作校验当前的 resourceVersion，
+		// 不一致时 apiserver 拒绝整个 patch（返回 422 而不是 409）
+		ops = append([]map[string]interface{}{{
+			"op":    "test",
+			"path":  "/metadata/resourceVersion",
+			"value": *opts.ResourceVersion,
+		}}, ops...)
+		return json.Marshal(ops)
+
+	case types.MergePatchType, types.StrategicMergePatchType, types.ApplyPatchType:
+		// apply patch 允许 yaml，统一转成 json 方便处理
+		patch, err := yaml.ToJSON(opts.Patch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", opts.PatchType, err)
+		}
+		if opts.ResourceVersion == nil {
+			return patch, nil
+		}
+		return addResourceVersion(patch, *opts.ResourceVersion)
+
+	default:
+		return nil, fmt.Errorf("unsupported patch type %q", opts.PatchType)
+	}
+}
@@ -24,6 +24,15 @@ import (
 	"k8s.io/kubectl/pkg/util/openapi"
 )
 
+const (
+	// maxPatchRetry is the maximum number of conflicts retry for during a patch operation before returning failure
+	maxPatchRetry = 5
+	// backOffPeriod is the period to back off when apply patch results in error.
+	backOffPeriod = 1 * time.Second
+	// how many times we can retry before back off
+	triesBeforeBackOff = 1
+)
+
 type Patcher struct {
 	Mapping *meta.RESTMapping
 	Helper  *resource.Helper
@@ -64,8 +73,26 @@ func NewPatcher(info *resource.Info, helper *resource.Helper) (*Patcher, error)
 
 // Patch patches the object in the cluster
 func (p *Patcher) Patch(obj runtime.Object, modified []byte, namespace string, name string) ([]byte, runtime.Object, error) {
-
-	return nil, nil, nil
+	var getErr error
+	patch, patchedObj, err := p.patchSimple(obj, modified, namespace, name)
+	if p.Retries == 0 {
+		p.Retries = maxPatchRetry
+	}
+	// 冲突时重新获取最新对象再计算 patch
+	for i := 1; i <= p.Retries && errors.IsConflict(err); i++ {
+		if i > triesBeforeBackOff {
+			p.BackOff.Sleep(backOffPeriod)
+		}
+		obj, getErr = p.Helper.Get(namespace, name)
+		if getErr != nil {
+			return nil, nil, getErr
+		}
+		patch, patchedObj, err = p.patchSimple(obj, modified, namespace, name)
+	}
+	if err != nil && (errors.IsConflict(err) || errors.IsInvalid(err)) && p.Force {
+		patch, patchedObj, err = p.deleteAndCreate(obj, modified, namespace, name)
+	}
+	return patch, patchedObj, err
 }
 
 func (p *Patcher) patchSimple(obj runtime.Object, modified []byte, namespace string, name string) ([]byte, runtime.Object, error) {
@@ -0,0 +1,88 @@
+package k8s_client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/polymorphichelpers"
+)
+
+const (
+	// DefaultReadyTimeout 等待资源就绪的默认超时时间
+	DefaultReadyTimeout = 5 * time.Minute
+	readyPollInterval   = 2 * time.Second
+)
+
+// WaitReady 等待对象就绪并返回最新的对象。
+// Deployment、DaemonSet、StatefulSet 等待 rollout 完成，Job 等待执行成功，其他资源存在即就绪
+func WaitReady(ctx context.Context, restConfig *rest.Config, mapper meta.RESTMapper, obj *unstructured.Unstructured, timeout time.Duration) (*unstructured.Unstructured, error) {
+	if timeout <= 0 {
+		timeout = DefaultReadyTimeout
+	}
+
+	helper, _, err := newResourceHelper(restConfig, mapper, obj.GroupVersionKind(), nil)
+	if err != nil {
+		return nil, err
+	}
+	namespace, name := obj.GetNamespace(), obj.GetName()
+
+	var latest *unstructured.Unstructured
+	err = wait.PollUntilContextTimeout(ctx, readyPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		current, err := helper.Get(namespace, name)
+		if err != nil {
+			return false, err
+		}
+		latest, err = toUnstructured(current)
+		if err != nil {
+			return false, err
+		}
+
+		msg, ready, err := isReady(latest)
+		if err != nil {
+			return false, err
+		}
+		if !ready {
+			klog.Infof("waiting for %s %s/%s: %s", latest.GetKind(), namespace, name, msg)
+		}
+		return ready, nil
+	})
+	if err != nil {
+		return latest, fmt.Errorf("wait for %s %s/%s ready: %w", obj.GetKind(), namespace, name, err)
+	}
+
+	return latest, nil
+}
+
+func isReady(obj *unstructured.Unstructured) (string, bool, error) {
+	gk := obj.GroupVersionKind().GroupKind()
+
+	if gk.Group == "batch" && gk.Kind == "Job" {
+		conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+		for _, c := range conditions {
+			condition, ok := c.(map[string]interface{})
+			if !ok || condition["status"] != "True" {
+				continue
+			}
+			switch condition["type"] {
+			case "Complete":
+				return "", true, nil
+			case "Failed":
+				return "", false, fmt.Errorf("job %s failed: %v", obj.GetName(), condition["message"])
+			}
+		}
+		return "job not complete", false, nil
+	}
+
+	viewer, err := polymorphichelpers.StatusViewerFor(gk)
+	if err != nil {
+		// 没有 rollout 状态的资源，创建成功即就绪
+		return "", true, nil
+	}
+	return viewer.Status(obj, 0)
+}
@@ -0,0 +1,147 @@
+package k8s_client
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// Warning apiserver 通过 Warning 头返回的告警，包括 API 废弃、准入控制告警等
+type Warning struct {
+	Code  int    `json:"code"`
+	Agent string `json:"agent,omitempty"`
+	Text  string `json:"text"`
+}
+
+func (w Warning) String() string {
+	return w.Text
+}
+
+// ClientSetWarnings 全局 clientset 以及未指定 WarningHandler 的 rest client 收到的告警，
+// 在进程内共享，只保留最近的 maxWarnings 条，按执行区分的告警见 Result.Warnings
+var ClientSetWarnings = NewWarningRecorder()
+
+// maxWarnings WarningRecorder 最多保留的告警数，超过后丢弃最早的
+const maxWarnings = 100
+
+// WarningRecorder 实现 rest.WarningHandler，记录一次操作过程中收到的所有告警
+type WarningRecorder struct {
+	mu       sync.Mutex
+	warnings []Warning
+}
+
+var _ rest.WarningHandler = &WarningRecorder{}
+
+func NewWarningRecorder() *WarningRecorder {
+	return &WarningRecorder{}
+}
+
+// HandleWarningHeader 只处理 299 告警，和 rest.WarningLogger 保持一致
+func (r *WarningRecorder) HandleWarningHeader(code int, agent string, text string) {
+	if code != 299 || len(text) == 0 {
+		return
+	}
+	klog.Warning(text)
+
+	r.Add(Warning{Code: code, Agent: agent, Text: text})
+}
+
+// Add 记录一条告警，相同内容只记录一次
+func (r *WarningRecorder) Add(w Warning) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, exist := range r.warnings {
+		if exist.Text == w.Text {
+			return
+		}
+	}
+	if len(r.warnings) >= maxWarnings {
+		r.warnings = append(r.warnings[:0], r.warnings[len(r.warnings)-maxWarnings+1:]...)
+	}
+	r.warnings = append(r.warnings, w)
+}
+
+// Reset 清空已记录的告警
+func (r *WarningRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.warnings = nil
+}
+
+// Warnings 返回已记录告警的拷贝
+func (r *WarningRecorder) Warnings() []Warning {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.warnings) == 0 {
+		return nil
+	}
+	return append([]Warning(nil), r.warnings...)
+}
+
+// deprecatedAPIs 已废弃或已移除的 apiVersion，value 为替代版本和移除版本说明
+var deprecatedAPIs = map[schema.GroupVersionKind]string{
+	{Group: "extensions", Version: "v1beta1", Kind: "Deployment"}:                                       "use apps/v1 Deployment, removed in v1.16",
+	{Group: "extensions", Version: "v1beta1", Kind: "DaemonSet"}:                                        "use apps/v1 DaemonSet, removed in v1.16",
+	{Group: "extensions", Version: "v1beta1", Kind: "ReplicaSet"}:                                       "use apps/v1 ReplicaSet, removed in v1.16",
+	{Group: "extensions", Version: "v1beta1", Kind: "NetworkPolicy"}:                                    "use networking.k8s.io/v1 NetworkPolicy, removed in v1.16",
+	{Group: "extensions", Version: "v1beta1", Kind: "PodSecurityPolicy"}:                                "removed in v1.16",
+	{Group: "extensions", Version: "v1beta1", Kind: "Ingress"}:                                          "use networking.k8s.io/v1 Ingress, removed in v1.22",
+	{Group: "apps", Version: "v1beta1", Kind: "Deployment"}:                                             "use apps/v1 Deployment, removed in v1.16",
+	{Group: "apps", Version: "v1beta1", Kind: "StatefulSet"}:                                            "use apps/v1 StatefulSet, removed in v1.16",
+	{Group: "apps", Version: "v1beta2", Kind: "Deployment"}:                                             "use apps/v1 Deployment, removed in v1.16",
+	{Group: "apps", Version: "v1beta2", Kind: "StatefulSet"}:                                            "use apps/v1 StatefulSet, removed in v1.16",
+	{Group: "apps", Version: "v1beta2", Kind: "DaemonSet"}:                                              "use apps/v1 DaemonSet, removed in v1.16",
+	{Group: "apps", Version: "v1beta2", Kind: "ReplicaSet"}:                                             "use apps/v1 ReplicaSet, removed in v1.16",
+	{Group: "networking.k8s.io", Version: "v1beta1", Kind: "Ingress"}:                                   "use networking.k8s.io/v1 Ingress, removed in v1.22",
+	{Group: "networking.k8s.io", Version: "v1beta1", Kind: "IngressClass"}:                              "use networking.k8s.io/v1 IngressClass, removed in v1.22",
+	{Group: "rbac.authorization.k8s.io", Version: "v1beta1", Kind: "Role"}:                              "use rbac.authorization.k8s.io/v1 Role, removed in v1.22",
+	{Group: "rbac.authorization.k8s.io", Version: "v1beta1", Kind: "RoleBinding"}:                       "use rbac.authorization.k8s.io/v1 RoleBinding, removed in v1.22",
+	{Group: "rbac.authorization.k8s.io", Version: "v1beta1", Kind: "ClusterRole"}:                       "use rbac.authorization.k8s.io/v1 ClusterRole, removed in v1.22",
+	{Group: "rbac.authorization.k8s.io", Version: "v1beta1", Kind: "ClusterRoleBinding"}:                "use rbac.authorization.k8s.io/v1 ClusterRoleBinding, removed in v1.22",
+	{Group: "apiextensions.k8s.io", Version: "v1beta1", Kind: "CustomResourceDefinition"}:               "use apiextensions.k8s.io/v1 CustomResourceDefinition, removed in v1.22",
+	{Group: "admissionregistration.k8s.io", Version: "v1beta1", Kind: "ValidatingWebhookConfiguration"}: "use admissionregistration.k8s.io/v1 ValidatingWebhookConfiguration, removed in v1.22",
+	{Group: "admissionregistration.k8s.io", Version: "v1beta1", Kind: "MutatingWebhookConfiguration"}:   "use admissionregistration.k8s.io/v1 MutatingWebhookConfiguration, removed in v1.22",
+	{Group: "scheduling.k8s.io", Version: "v1beta1", Kind: "PriorityClass"}:                             "use scheduling.k8s.io/v1 PriorityClass, removed in v1.22",
+	{Group: "storage.k8s.io", Version: "v1beta1", Kind: "CSIDriver"}:                                    "use storage.k8s.io/v1 CSIDriver, removed in v1.22",
+	{Group: "storage.k8s.io", Version: "v1beta1", Kind: "StorageClass"}:                                 "use storage.k8s.io/v1 StorageClass, removed in v1.22",
+	{Group: "storage.k8s.io", Version: "v1beta1", Kind: "CSIStorageCapacity"}:                           "use storage.k8s.io/v1 CSIStorageCapacity, removed in v1.27",
+	{Group: "batch", Version: "v1beta1", Kind: "CronJob"}:                                               "use batch/v1 CronJob, removed in v1.25",
+	{Group: "discovery.k8s.io", Version: "v1beta1", Kind: "EndpointSlice"}:                              "use discovery.k8s.io/v1 EndpointSlice, removed in v1.25",
+	{Group: "events.k8s.io", Version: "v1beta1", Kind: "Event"}:                                         "use events.k8s.io/v1 Event, removed in v1.25",
+	{Group: "autoscaling", Version: "v2beta1", Kind: "HorizontalPodAutoscaler"}:                         "use autoscaling/v2 HorizontalPodAutoscaler, removed in v1.25",
+	{Group: "autoscaling", Version: "v2beta2", Kind: "HorizontalPodAutoscaler"}:                         "use autoscaling/v2 HorizontalPodAutoscaler, removed in v1.26",
+	{Group: "policy", Version: "v1beta1", Kind: "PodDisruptionBudget"}:                                  "use policy/v1 PodDisruptionBudget, removed in v1.25",
+	{Group: "policy", Version: "v1beta1", Kind: "PodSecurityPolicy"}:                                    "removed in v1.25",
+	{Group: "node.k8s.io", Version: "v1beta1", Kind: "RuntimeClass"}:                                    "use node.k8s.io/v1 RuntimeClass, removed in v1.25",
+	{Group: "flowcontrol.apiserver.k8s.io", Version: "v1beta1", Kind: "FlowSchema"}:                     "use flowcontrol.apiserver.k8s.io/v1 FlowSchema, removed in v1.26",
+	{Group: "flowcontrol.apiserver.k8s.io", Version: "v1beta1", Kind: "PriorityLevelConfiguration"}:     "use flowcontrol.apiserver.k8s.io/v1 PriorityLevelConfiguration, removed in v1.26",
+	{Group: "flowcontrol.apiserver.k8s.io", Version: "v1beta2", Kind: "FlowSchema"}:                     "use flowcontrol.apiserver.k8s.io/v1 FlowSchema, removed in v1.29",
+	{Group: "flowcontrol.apiserver.k8s.io", Version: "v1beta2", Kind: "PriorityLevelConfiguration"}:     "use flowcontrol.apiserver.k8s.io/v1 PriorityLevelConfiguration, removed in v1.29",
+	{Group: "flowcontrol.apiserver.k8s.io", Version: "v1beta3", Kind: "FlowSchema"}:                     "use flowcontrol.apiserver.k8s.io/v1 FlowSchema, removed in v1.32",
+	{Group: "flowcontrol.apiserver.k8s.io", Version: "v1beta3", Kind: "PriorityLevelConfiguration"}:     "use flowcontrol.apiserver.k8s.io/v1 PriorityLevelConfiguration, removed in v1.32",
+}
+
+// CheckDeprecatedAPI 检查渲染出来的对象是否使用了已废弃的 apiVersion，
+// 在 apply 之前就能提示，不用等 apiserver 返回
+func CheckDeprecatedAPI(apiVersion, kind string) (Warning, bool) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return Warning{}, false
+	}
+
+	msg, ok := deprecatedAPIs[gv.WithKind(kind)]
+	if !ok {
+		return Warning{}, false
+	}
+
+	return Warning{
+		Code:  299,
+		Agent: "k8s-operator",
+		Text:  fmt.Sprintf("%s %s is deprecated: %s", apiVersion, kind, msg),
+	}, true
+}
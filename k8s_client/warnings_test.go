@@ -0,0 +1,83 @@
+package k8s_client
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCheckDeprecatedAPI(t *testing.T) {
+	tests := []struct {
+		apiVersion, kind string
+		deprecated       bool
+		replacement      string
+	}{
+		{"extensions/v1beta1", "Deployment", true, "apps/v1 Deployment"},
+		{"networking.k8s.io/v1beta1", "Ingress", true, "networking.k8s.io/v1 Ingress"},
+		{"batch/v1beta1", "CronJob", true, "batch/v1 CronJob"},
+		{"policy/v1beta1", "PodSecurityPolicy", true, "removed in v1.25"},
+		{"apps/v1", "Deployment", false, ""},
+		{"v1", "Service", false, ""},
+		// 同一个 apiVersion 下没有废弃的 kind
+		{"batch/v1beta1", "Job", false, ""},
+		{"a/b/c", "Deployment", false, ""},
+	}
+	for _, tt := range tests {
+		w, deprecated := CheckDeprecatedAPI(tt.apiVersion, tt.kind)
+		if deprecated != tt.deprecated {
+			t.Errorf("CheckDeprecatedAPI(%s, %s) = %v, want %v", tt.apiVersion, tt.kind, deprecated, tt.deprecated)
+			continue
+		}
+		if !deprecated {
+			if w != (Warning{}) {
+				t.Errorf("CheckDeprecatedAPI(%s, %s) returned %+v for a supported api", tt.apiVersion, tt.kind, w)
+			}
+			continue
+		}
+		if w.Code != 299 || !strings.HasPrefix(w.Text, tt.apiVersion+" "+tt.kind+" is deprecated") || !strings.Contains(w.Text, tt.replacement) {
+			t.Errorf("CheckDeprecatedAPI(%s, %s) = %+v", tt.apiVersion, tt.kind, w)
+		}
+	}
+}
+
+func TestWarningRecorder(t *testing.T) {
+	r := NewWarningRecorder()
+	if r.Warnings() != nil {
+		t.Fatal("new recorder should have no warnings")
+	}
+
+	// 只记录 299 告警，相同内容只记录一次
+	r.HandleWarningHeader(299, "apiserver", "v1beta1 is deprecated")
+	r.HandleWarningHeader(299, "apiserver", "v1beta1 is deprecated")
+	r.HandleWarningHeader(199, "apiserver", "misc")
+	r.HandleWarningHeader(299, "apiserver", "")
+	if got := r.Warnings(); len(got) != 1 || got[0].Text != "v1beta1 is deprecated" || got[0].Agent != "apiserver" {
+		t.Errorf("warnings = %+v", got)
+	}
+
+	// 返回的是拷贝
+	r.Warnings()[0].Text = "changed"
+	if r.Warnings()[0].Text != "v1beta1 is deprecated" {
+		t.Error("Warnings() should return a copy")
+	}
+
+	r.Reset()
+	if r.Warnings() != nil {
+		t.Errorf("warnings after Reset = %+v", r.Warnings())
+	}
+}
+
+func TestWarningRecorderCap(t *testing.T) {
+	r := NewWarningRecorder()
+	for i := 0; i < maxWarnings+10; i++ {
+		r.Add(Warning{Code: 299, Text: fmt.Sprintf("warning %d", i)})
+	}
+	got := r.Warnings()
+	if len(got) != maxWarnings {
+		t.Fatalf("kept %d warnings, want %d", len(got), maxWarnings)
+	}
+	// 丢弃最早的告警
+	if got[0].Text != "warning 10" || got[len(got)-1].Text != fmt.Sprintf("warning %d", maxWarnings+9) {
+		t.Errorf("kept %s .. %s", got[0].Text, got[len(got)-1].Text)
+	}
+}
@@ -0,0 +1,128 @@
+package k8s_flow
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultApprover 不在 Run 中执行的 approve 节点使用的审批器，Run 中的审批见 Run.Approver
+var DefaultApprover = NewApprover()
+
+type approverKey struct{}
+
+func withApprover(ctx context.Context, a *Approver) context.Context {
+	return context.WithValue(ctx, approverKey{}, a)
+}
+
+// approverFrom 节点所在 Run 的审批器，不在 Run 中时为 DefaultApprover
+func approverFrom(ctx context.Context) *Approver {
+	if a, ok := ctx.Value(approverKey{}).(*Approver); ok {
+		return a
+	}
+	return DefaultApprover
+}
+
+// WithApprover 指定执行使用的审批器，缺省每个 Run 独立，同名审批不会互相影响
+func WithApprover(a *Approver) RunOption {
+	return func(r *Run) {
+		r.approver = a
+	}
+}
+
+// Approval 一条待审批记录
+type Approval struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+type approvalResult struct {
+	by     string
+	reason string
+	ok     bool
+}
+
+// Approver 管理等待中的审批，Approve、Reject 可以在 Wait 之前调用
+type Approver struct {
+	mu      sync.Mutex
+	pending map[string]*Approval
+	results map[string]chan approvalResult
+}
+
+func NewApprover() *Approver {
+	return &Approver{
+		pending: map[string]*Approval{},
+		results: map[string]chan approvalResult{},
+	}
+}
+
+func (a *Approver) resultCh(name string) chan approvalResult {
+	ch, ok := a.results[name]
+	if !ok {
+		ch = make(chan approvalResult, 1)
+		a.results[name] = ch
+	}
+	return ch
+}
+
+// Wait 阻塞直到 name 被审批或 ctx 结束，返回审批人
+func (a *Approver) Wait(ctx context.Context, name, message string) (string, error) {
+	a.mu.Lock()
+	a.pending[name] = &Approval{Name: name, Message: message}
+	ch := a.resultCh(name)
+	a.mu.Unlock()
+
+	defer func() {
+		a.mu.Lock()
+		delete(a.pending, name)
+		delete(a.results, name)
+		a.mu.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case result := <-ch:
+		if !result.ok {
+			return "", fmt.Errorf("approval %q rejected by %s: %s", name, result.by, result.reason)
+		}
+		return result.by, nil
+	}
+}
+
+func (a *Approver) resolve(name string, result approvalResult) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	select {
+	case a.resultCh(name) <- result:
+	default:
+		// 已经有审批结果，忽略重复操作
+	}
+}
+
+// Approve 审批通过
+func (a *Approver) Approve(name, by string) {
+	a.resolve(name, approvalResult{by: by, ok: true})
+}
+
+// Reject 审批拒绝，对应节点失败
+func (a *Approver) Reject(name, by, reason string) {
+	a.resolve(name, approvalResult{by: by, reason: reason})
+}
+
+// Pending 返回等待中的审批
+func (a *Approver) Pending() []Approval {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	approvals := make([]Approval, 0, len(a.pending))
+	for _, approval := range a.pending {
+		approvals = append(approvals, *approval)
+	}
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].Name < approvals[j].Name
+	})
+	return approvals
+}
@@ -0,0 +1,146 @@
+package k8s_flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultGracePeriod 取消后等待正在运行的节点退出的缺省时长，
+// 要小于 Pod 的 terminationGracePeriodSeconds（缺省 30s），留出保存状态的时间
+const DefaultGracePeriod = 10 * time.Second
+
+// ErrCancelled 执行被取消，Run 返回的错误可以用 errors.Is 判断
+var ErrCancelled = errors.New("workflow cancelled")
+
+// saveTimeout 取消后保存状态的超时，保存不受执行 ctx 取消的影响
+const saveTimeout = 10 * time.Second
+
+// WithGracePeriod 执行被取消、超时或有节点失败后，等待其他正在运行的节点退出的时长，
+// 节点的 ctx 立即取消，超过该时长仍未退出的节点标记为 Cancelled，不再等待。缺省 DefaultGracePeriod
+func WithGracePeriod(d time.Duration) RunOption {
+	return func(r *Run) {
+		r.gracePeriod = d
+	}
+}
+
+// WithTimeout 整个工作流的超时，超时后取消所有节点，执行失败，配置了补偿时照常补偿
+func WithTimeout(d time.Duration) RunOption {
+	return func(r *Run) {
+		r.timeout = d
+	}
+}
+
+// runContext 节点拿到的 ctx，配置了超时时加上 deadline
+func (r *Run) runContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout > 0 {
+		return context.WithTimeout(ctx, r.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// begin 登记正在运行的节点，返回节点退出时调用的函数，drain 之后启动的节点不再运行
+func (r *Run) begin(path string) (func(), bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.draining {
+		return nil, false
+	}
+	done := make(chan struct{})
+	r.inflight[path] = done
+	return func() {
+		r.mu.Lock()
+		delete(r.inflight, path)
+		r.mu.Unlock()
+		close(done)
+	}, true
+}
+
+// drain flow 在取消或有节点失败时直接返回，不等待其他正在运行的节点，
+// 这里最多等待 gracePeriod，仍在运行的节点标记为 Cancelled 并保存状态
+func (r *Run) drain(ctx context.Context) {
+	r.mu.Lock()
+	r.draining = true
+	var waiting []chan struct{}
+	for _, done := range r.inflight {
+		waiting = append(waiting, done)
+	}
+	r.mu.Unlock()
+
+	timer := time.NewTimer(r.gracePeriod)
+	defer timer.Stop()
+wait:
+	for _, done := range waiting {
+		select {
+		case <-done:
+		case <-timer.C:
+			break wait
+		}
+	}
+
+	stuck := false
+	final := map[string]TaskStatus{}
+	r.mu.Lock()
+	for path, status := range r.tasks {
+		if status.Phase == TaskRunning {
+			status.Phase = TaskCancelled
+			status.FinishedAt = time.Now()
+			status.Error = fmt.Sprintf("did not stop within the grace period %v", r.gracePeriod)
+			stuck = true
+		}
+		if status.Phase == TaskCancelled || status.Phase == TaskFailed {
+			final[path] = *status
+		}
+	}
+	r.mu.Unlock()
+
+	// flow 返回后不再通知节点的变化，补发取消和失败的节点事件，已经发布过的会被忽略
+	paths := make([]string, 0, len(final))
+	for path := range final {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		status := final[path]
+		state := StateFailed
+		if status.Phase == TaskCancelled {
+			state = StateCancelled
+		}
+		var err error
+		if status.Error != "" {
+			err = errors.New(status.Error)
+		}
+		r.publishTask(path, state, err)
+	}
+	if stuck {
+		r.save(ctx)
+	}
+}
+
+// cancelled 执行是否因为 ctx 取消或超时而没有完成，
+// 取消和最后一个节点完成同时发生时 flow 已经正常结束，不算取消
+func (r *Run) cancelled(runCtx context.Context) bool {
+	if runCtx.Err() == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, status := range r.tasks {
+		switch status.Phase {
+		case TaskPending, TaskRunning, TaskCancelled:
+			return true
+		}
+	}
+	return false
+}
+
+// cancelErr 外部取消返回 ErrCancelled，同时包装取消的原因（如 ErrLockLost），工作流超时算作失败
+func (r *Run) cancelErr(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", ErrCancelled, context.Cause(ctx))
+	}
+	return fmt.Errorf("workflow timed out after %v", r.timeout)
+}
@@ -0,0 +1,188 @@
+package k8s_flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"k8s.io/klog/v2"
+)
+
+// CompensationMode 工作流失败后是否执行补偿
+type CompensationMode string
+
+const (
+	// CompensateNever 失败后保留现场，缺省值
+	CompensateNever CompensationMode = "never"
+	// CompensateAlways 失败后立即按完成顺序倒序补偿
+	CompensateAlways CompensationMode = "always"
+	// CompensateRequireApproval 失败后等待名为 compensate 的审批（见 Run.Approver），审批通过才补偿
+	CompensateRequireApproval CompensationMode = "require-approval"
+)
+
+// CompensateApproval require-approval 模式下补偿前等待的审批名称
+const CompensateApproval = "compensate"
+
+// ParseCompensationMode 解析补偿模式，空字符串为 never
+func ParseCompensationMode(s string) (CompensationMode, error) {
+	switch mode := CompensationMode(s); mode {
+	case "":
+		return CompensateNever, nil
+	case CompensateNever, CompensateAlways, CompensateRequireApproval:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown compensation mode %q, must be one of never, always, require-approval", s)
+	}
+}
+
+// UndoRecord 节点执行时记录的撤销信息，补偿时交给 Kind 对应节点类型的 Compensate
+type UndoRecord struct {
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data"`
+}
+
+type undoKey struct{}
+
+func withUndo(ctx context.Context, record func(UndoRecord)) context.Context {
+	return context.WithValue(ctx, undoKey{}, record)
+}
+
+// RecordUndo 节点类型在 Run 中调用，记录补偿时需要的信息，没有通过 Run 执行时忽略。
+// 同一个节点可以记录多次（重试、foreach 子节点），补偿时倒序执行
+func RecordUndo(ctx context.Context, kind string, data interface{}) error {
+	record, ok := ctx.Value(undoKey{}).(func(UndoRecord))
+	if !ok {
+		return nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	record(UndoRecord{Kind: kind, Data: raw})
+	return nil
+}
+
+// compensate 按完成顺序倒序补偿，返回补偿失败的错误
+func (r *Run) compensate(ctx context.Context, cause error) error {
+	r.mu.RLock()
+	order := append([]string(nil), r.completed...)
+	r.mu.RUnlock()
+	if len(order) == 0 {
+		return nil
+	}
+
+	if r.compensation == CompensateRequireApproval {
+		name := CompensateApproval
+		message := fmt.Sprintf("workflow failed: %v\ncompensate tasks: %s", cause, strings.Join(reversed(order), ", "))
+		by, err := r.approver.Wait(ctx, name, message)
+		if err != nil {
+			klog.Warningf("run %s: compensation not approved: %v", r.id, err)
+			return nil
+		}
+		klog.Infof("run %s: compensation approved by %s", r.id, by)
+	}
+
+	var errs []string
+	for i := len(order) - 1; i >= 0; i-- {
+		path := order[i]
+		status, _ := r.Task(path)
+		klog.Infof("run %s: compensate task %s", r.id, path)
+
+		// 补偿修改的对象同样记录到节点上
+		taskCtx := withObjects(withApprover(ctx, r.approver), func(c ObjectChange) {
+			r.update(path, func(status *TaskStatus) {
+				status.Objects = append(status.Objects, c)
+			})
+		})
+		compensated, err := r.compensateTask(taskCtx, status)
+		if !compensated && err == nil {
+			continue
+		}
+		r.update(path, func(status *TaskStatus) {
+			status.Compensated = err == nil
+			if err != nil {
+				status.CompensationError = err.Error()
+				return
+			}
+			if status.Phase == TaskSucceeded {
+				// 已补偿的节点恢复执行时需要重新执行
+				status.Phase = TaskCompensated
+			}
+		})
+		r.save(ctx)
+		if err != nil {
+			klog.Errorf("run %s: compensate task %s failed: %v", r.id, path, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", path, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("compensation failed:\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// compensateTask 节点声明了 compensate 时执行声明的节点，为 false 时不补偿，
+// 否则按节点记录的撤销信息执行缺省补偿，没有需要补偿的内容时返回 false
+func (r *Run) compensateTask(ctx context.Context, status TaskStatus) (bool, error) {
+	v := r.controller.Value().LookupPath(cue.ParsePath(status.Path))
+	declared := v.LookupPath(cue.ParsePath("compensate"))
+	if declared.Exists() {
+		if enabled, err := declared.Bool(); err == nil {
+			if !enabled {
+				return false, nil
+			}
+		} else {
+			if status.Phase != TaskSucceeded {
+				return false, nil
+			}
+			return true, runCompensateTask(ctx, declared)
+		}
+	}
+
+	for i := len(status.Undo) - 1; i >= 0; i-- {
+		undo := status.Undo[i]
+		kind, ok := LookupTaskKind(undo.Kind)
+		if !ok || kind.Compensate == nil {
+			return true, fmt.Errorf("task kind %q does not support compensation", undo.Kind)
+		}
+		if err := kind.Compensate(ctx, undo.Data); err != nil {
+			return true, err
+		}
+	}
+	return len(status.Undo) > 0, nil
+}
+
+// runCompensateTask 执行节点中声明的补偿节点，同样支持 retry、timeout
+func runCompensateTask(ctx context.Context, v cue.Value) error {
+	kind, err := newTask(v)
+	if err != nil {
+		return err
+	}
+	if kind == nil {
+		return fmt.Errorf("compensate of %v must declare a task kind", v.Path())
+	}
+
+	in, err := kind.input(v)
+	if err != nil {
+		return err
+	}
+	policy, err := parseRetryPolicy(in)
+	if err != nil {
+		return err
+	}
+	_, err = runWithRetry(ctx, policy, func(Attempt) {}, func(ctx context.Context) (interface{}, error) {
+		return kind.Run(ctx, in)
+	})
+	return err
+}
+
+func reversed(s []string) []string {
+	out := make([]string, 0, len(s))
+	for i := len(s) - 1; i >= 0; i-- {
+		out = append(out, s[i])
+	}
+	return out
+}
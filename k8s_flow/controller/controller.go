@@ -0,0 +1,529 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/tools/flow"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/penk110/k8s_operator/k8s_client"
+	"github.com/penk110/k8s_operator/k8s_flow"
+)
+
+// statusTimeout operator 退出时写入中断状态的超时
+const statusTimeout = 10 * time.Second
+
+// activeRun 正在执行的某个 generation 的工作流
+type activeRun struct {
+	generation int64
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+// Controller 监听 Workflow 资源，spec generation 变化时用 cue 工作流引擎重新执行，
+// 并把每个节点的状态和 conditions 写回 .status
+type Controller struct {
+	client dynamic.Interface
+	kube   kubernetes.Interface
+
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	informer cache.SharedIndexInformer
+	queue    workqueue.RateLimitingInterface
+
+	// GracePeriod 取消执行后等待正在运行的节点退出的时长，缺省 k8s_flow.DefaultGracePeriod
+	GracePeriod time.Duration
+	// History 不为空时每次执行写入执行历史，工作流名为 namespace/name
+	History k8s_flow.HistoryStore
+
+	mu     sync.Mutex
+	active map[string]*activeRun
+}
+
+// New 创建控制器，namespace 为空时监听所有命名空间
+func New(namespace string) *Controller {
+	c := &Controller{
+		client:  k8s_client.GetDynamicClient(),
+		kube:    k8s_client.GetClientSet(),
+		factory: k8s_client.InitDynamicWatch(namespace, 10*time.Minute),
+		queue:   workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		active:  map[string]*activeRun{},
+	}
+
+	c.informer = c.factory.ForResource(WorkflowGVR).Informer()
+	_, _ = c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.enqueue(newObj)
+		},
+		DeleteFunc: c.enqueue,
+	})
+	return c
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// Run 启动 informer 和 workers，阻塞直到 ctx 结束（如 Pod 被驱逐收到 SIGTERM）。
+// 结束时取消所有正在执行的工作流，等待节点退出、保存状态并写回 .status 后返回，
+// operator 重启后从保存的状态继续执行
+func (c *Controller) Run(ctx context.Context, workers int) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	c.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) {
+		return fmt.Errorf("wait for workflow informer cache sync failed")
+	}
+	klog.Infof("workflow controller started with %d workers", workers)
+
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.worker, time.Second)
+	}
+	<-ctx.Done()
+
+	c.mu.Lock()
+	var running []*activeRun
+	for _, act := range c.active {
+		act.cancel()
+		running = append(running, act)
+	}
+	c.mu.Unlock()
+	for _, act := range running {
+		<-act.done
+	}
+	klog.Infof("workflow controller stopped, %d running workflow(s) interrupted", len(running))
+	return nil
+}
+
+func (c *Controller) worker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	item, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	key := item.(string)
+	if err := c.reconcile(ctx, key); err != nil {
+		klog.Errorf("reconcile workflow %s failed: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *Controller) reconcile(ctx context.Context, key string) error {
+	obj, exists, err := c.informer.GetIndexer().GetByKey(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		c.stop(key)
+		return nil
+	}
+
+	wf := &Workflow{}
+	if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.(*unstructured.Unstructured).Object, wf); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	act, running := c.active[key]
+	c.mu.Unlock()
+	if running {
+		if act.generation == wf.Generation {
+			return nil
+		}
+		// spec 变化，取消旧 generation 的执行
+		klog.Infof("workflow %s generation changed %d -> %d, restart", key, act.generation, wf.Generation)
+		c.stop(key)
+	}
+	if wf.finished() {
+		return nil
+	}
+	// informer 的缓存可能还没有收到刚写入的最终状态，开始前读取最新的对象，避免重复执行已经结束的 generation
+	live, err := c.client.Resource(WorkflowGVR).Namespace(wf.Namespace).Get(ctx, wf.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	wf = &Workflow{}
+	if err = runtime.DefaultUnstructuredConverter.FromUnstructured(live.Object, wf); err != nil {
+		return err
+	}
+	if wf.finished() {
+		return nil
+	}
+
+	return c.start(ctx, key, wf)
+}
+
+// stop 取消并等待正在执行的工作流退出
+func (c *Controller) stop(key string) {
+	c.mu.Lock()
+	act, ok := c.active[key]
+	delete(c.active, key)
+	c.mu.Unlock()
+
+	if ok {
+		act.cancel()
+		<-act.done
+	}
+}
+
+// triggeredBy 触发执行的用户，优先取 TriggeredByAnnotation，否则取最后修改 spec 的 field manager，如 kubectl-client-side-apply
+func triggeredBy(wf *Workflow) string {
+	if user := wf.Annotations[TriggeredByAnnotation]; user != "" {
+		return user
+	}
+	var latest *metav1.ManagedFieldsEntry
+	for i, entry := range wf.ManagedFields {
+		if entry.Subresource == "status" || entry.Time == nil {
+			continue
+		}
+		if latest == nil || !entry.Time.Before(latest.Time) {
+			latest = &wf.ManagedFields[i]
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.Manager
+}
+
+func (c *Controller) start(ctx context.Context, key string, wf *Workflow) error {
+	// runID 包含 generation，operator 重启后同一个 generation 会从 ConfigMap 中恢复执行
+	runID := fmt.Sprintf("%s-%d", wf.Name, wf.Generation)
+	var compensation k8s_flow.CompensationMode
+
+	var timeout time.Duration
+
+	v, err := c.compile(ctx, wf)
+	if err == nil {
+		compensation, err = k8s_flow.ParseCompensationMode(wf.Spec.Compensation)
+	}
+	if err == nil && wf.Spec.Timeout != "" {
+		if timeout, err = time.ParseDuration(wf.Spec.Timeout); err != nil {
+			err = fmt.Errorf("invalid spec.timeout: %v", err)
+		}
+	}
+	if err != nil {
+		return c.updateStatus(ctx, wf, func(status *WorkflowStatus) {
+			now := metav1.Now()
+			status.Phase = WorkflowFailed
+			status.RunID = runID
+			status.Message = err.Error()
+			status.FinishedAt = &now
+			setCondition(status, wf.Generation, ConditionProgressing, metav1.ConditionFalse, "InvalidSpec", err.Error())
+			setCondition(status, wf.Generation, ConditionSucceeded, metav1.ConditionFalse, "InvalidSpec", err.Error())
+		})
+	}
+
+	updated := make(chan struct{}, 1)
+	cfg := &flow.Config{
+		UpdateFunc: func(_ *flow.Controller, _ *flow.Task) error {
+			select {
+			case updated <- struct{}{}:
+			default:
+			}
+			return nil
+		},
+	}
+	if wf.Spec.Root != "" {
+		cfg.Root = cue.ParsePath(wf.Spec.Root)
+	}
+	store := k8s_flow.NewConfigMapStore(c.kube, wf.Namespace)
+	// 不设置 blockOwnerDeletion，不需要 workflows/finalizers 的权限
+	store.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: WorkflowGVK.GroupVersion().String(),
+		Kind:       WorkflowGVK.Kind,
+		Name:       wf.Name,
+		UID:        wf.UID,
+	}}
+	opts := []k8s_flow.RunOption{
+		k8s_flow.WithID(runID),
+		k8s_flow.WithStateStore(store),
+		k8s_flow.WithCompensation(compensation),
+	}
+	if c.GracePeriod > 0 {
+		opts = append(opts, k8s_flow.WithGracePeriod(c.GracePeriod))
+	}
+	if timeout > 0 {
+		// 恢复执行时扣除已经用掉的时间
+		if wf.Status.RunID == runID && wf.Status.StartedAt != nil {
+			timeout -= time.Since(wf.Status.StartedAt.Time)
+		}
+		if timeout <= 0 {
+			timeout = time.Nanosecond
+		}
+		opts = append(opts, k8s_flow.WithTimeout(timeout))
+	}
+	if wf.Spec.OutputsConfigMap != "" {
+		opts = append(opts, k8s_flow.WithOutputExporters(&k8s_flow.ConfigMapExporter{
+			Client:    c.kube,
+			Namespace: wf.Namespace,
+			Name:      wf.Spec.OutputsConfigMap,
+		}))
+	}
+	if c.History != nil {
+		opts = append(opts, k8s_flow.WithHistory(c.History, k8s_flow.RunMeta{
+			Workflow: key,
+			User:     triggeredBy(wf),
+			Trigger:  "operator",
+			Params:   wf.Spec.Inputs,
+		}))
+	}
+	run := k8s_flow.NewRun(cfg, v, opts...)
+
+	err = c.updateStatus(ctx, wf, func(status *WorkflowStatus) {
+		now := metav1.Now()
+		if status.RunID != runID || status.StartedAt == nil {
+			status.StartedAt = &now
+		}
+		status.Phase = WorkflowRunning
+		status.RunID = runID
+		status.Message = ""
+		status.FinishedAt = nil
+		status.Outputs = nil
+		status.Tasks = taskStatuses(run)
+		setCondition(status, wf.Generation, ConditionProgressing, metav1.ConditionTrue, "Running", "workflow is running")
+		setCondition(status, wf.Generation, ConditionSucceeded, metav1.ConditionUnknown, "Running", "workflow is running")
+	})
+	if err != nil {
+		return err
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	act := &activeRun{generation: wf.Generation, cancel: cancel, done: make(chan struct{})}
+	c.mu.Lock()
+	c.active[key] = act
+	c.mu.Unlock()
+
+	finished := make(chan error, 1)
+	go func() {
+		finished <- run.Run(runCtx)
+	}()
+
+	go func() {
+		defer close(act.done)
+		defer cancel()
+		for {
+			select {
+			case <-updated:
+				err := c.updateStatus(ctx, wf, func(status *WorkflowStatus) {
+					status.Tasks = taskStatuses(run)
+				})
+				if err != nil {
+					klog.Errorf("update workflow %s status failed: %v", key, err)
+				}
+			case err := <-finished:
+				c.finish(ctx, key, wf, run, runCtx, store, err)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// finish 写入最终状态，被取消（spec 变化或删除）的执行不写状态，由新的执行接管。
+// operator 退出时 ctx 已经取消，用新的 ctx 写入中断状态，重启后 reconcile 从保存的状态继续执行。
+// 写入状态之后才移除 active，期间的 reconcile 看到执行仍在进行，不会重新开始
+func (c *Controller) finish(ctx context.Context, key string, wf *Workflow, run *k8s_flow.Run, runCtx context.Context, store k8s_flow.StateStore, err error) {
+	defer func() {
+		c.mu.Lock()
+		if act, ok := c.active[key]; ok && act.generation == wf.Generation {
+			delete(c.active, key)
+		}
+		c.mu.Unlock()
+	}()
+
+	if ctx.Err() != nil {
+		klog.Infof("workflow %s generation %d interrupted by operator shutdown", key, wf.Generation)
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), statusTimeout)
+		defer cancel()
+		updateErr := c.updateStatus(ctx, wf, func(status *WorkflowStatus) {
+			status.Phase = WorkflowPending
+			status.Message = "operator stopped, the workflow resumes when it restarts"
+			status.Tasks = taskStatuses(run)
+			setCondition(status, wf.Generation, ConditionProgressing, metav1.ConditionFalse, "Interrupted", status.Message)
+		})
+		if updateErr != nil {
+			klog.Errorf("update workflow %s status failed: %v", key, updateErr)
+		}
+		return
+	}
+	if errors.Is(err, k8s_flow.ErrLockLost) {
+		// 其他 operator 实例已经接管，由它写入状态
+		klog.Warningf("workflow %s generation %d stopped: %v", key, wf.Generation, err)
+		return
+	}
+	if runCtx.Err() != nil {
+		// spec 变化或删除，旧 generation 不会再恢复
+		klog.Infof("workflow %s generation %d cancelled", key, wf.Generation)
+		c.deleteState(ctx, key, run, store)
+		return
+	}
+
+	updateErr := c.updateStatus(ctx, wf, func(status *WorkflowStatus) {
+		now := metav1.Now()
+		status.FinishedAt = &now
+		status.Tasks = taskStatuses(run)
+		if err != nil {
+			status.Phase = WorkflowFailed
+			status.Message = err.Error()
+			setCondition(status, wf.Generation, ConditionProgressing, metav1.ConditionFalse, "Failed", err.Error())
+			setCondition(status, wf.Generation, ConditionSucceeded, metav1.ConditionFalse, "TaskFailed", err.Error())
+			return
+		}
+		status.Phase = WorkflowSucceeded
+		status.Message = ""
+		status.Outputs = run.Outputs()
+		setCondition(status, wf.Generation, ConditionProgressing, metav1.ConditionFalse, "Completed", "workflow completed")
+		setCondition(status, wf.Generation, ConditionSucceeded, metav1.ConditionTrue, "Completed", "workflow completed")
+	})
+	if updateErr != nil {
+		// 保留状态，重新 reconcile 时恢复执行，不会重复执行已成功的节点
+		klog.Errorf("update workflow %s final status failed: %v", key, updateErr)
+		return
+	}
+	c.deleteState(ctx, key, run, store)
+}
+
+// deleteState 执行结束后删除保存的状态，最终结果已经写入 .status
+func (c *Controller) deleteState(ctx context.Context, key string, run *k8s_flow.Run, store k8s_flow.StateStore) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), statusTimeout)
+	defer cancel()
+	if err := store.Delete(ctx, run.ID()); err != nil {
+		klog.Errorf("delete workflow %s run state %s failed: %v", key, run.ID(), err)
+	}
+}
+
+// compile 读取 cue 源码、合并 spec.inputs 并做静态检查
+func (c *Controller) compile(ctx context.Context, wf *Workflow) (cue.Value, error) {
+	source := wf.Spec.Source
+	filename := wf.Name + ".cue"
+	if ref := wf.Spec.ConfigMapRef; ref != nil {
+		cm, err := c.kube.CoreV1().ConfigMaps(wf.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return cue.Value{}, fmt.Errorf("get source configmap %s: %v", ref.Name, err)
+		}
+		key := ref.Key
+		if key == "" {
+			key = DefaultSourceKey
+		}
+		var ok bool
+		if source, ok = cm.Data[key]; !ok {
+			return cue.Value{}, fmt.Errorf("key %s not found in configmap %s", key, ref.Name)
+		}
+		filename = ref.Name + "/" + key
+	}
+	if source == "" {
+		return cue.Value{}, fmt.Errorf("one of spec.source or spec.configMapRef is required")
+	}
+
+	v := cuecontext.New().CompileString(source, cue.Filename(filename))
+	if v.Err() != nil {
+		return v, v.Err()
+	}
+	v, err := k8s_flow.ApplyInputs(v, wf.Spec.Inputs)
+	if err != nil {
+		return v, err
+	}
+	cfg := &flow.Config{}
+	if wf.Spec.Root != "" {
+		cfg.Root = cue.ParsePath(wf.Spec.Root)
+	}
+	return v, k8s_flow.CheckLint(cfg, v, nil)
+}
+
+// updateStatus 基于最新的对象修改 status，对象已经进入新的 generation 时不再写入
+func (c *Controller) updateStatus(ctx context.Context, wf *Workflow, fn func(status *WorkflowStatus)) error {
+	workflows := c.client.Resource(WorkflowGVR).Namespace(wf.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := workflows.Get(ctx, wf.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if obj.GetGeneration() != wf.Generation {
+			return nil
+		}
+
+		latest := &Workflow{}
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, latest); err != nil {
+			return err
+		}
+		latest.Status.ObservedGeneration = wf.Generation
+		fn(&latest.Status)
+
+		status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&latest.Status)
+		if err != nil {
+			return err
+		}
+		if err = unstructured.SetNestedField(obj.Object, status, "status"); err != nil {
+			return err
+		}
+		_, err = workflows.UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// maxStatusObjects .status 中每个节点最多保留的修改过的对象，完整记录见执行历史
+const maxStatusObjects = 10
+
+// taskStatuses 写入 .status 的节点状态，去掉输出和撤销信息（包含之前对象的完整副本）避免超过对象大小上限
+func taskStatuses(run *k8s_flow.Run) []k8s_flow.TaskStatus {
+	tasks := run.Tasks()
+	for i := range tasks {
+		tasks[i].Output = nil
+		tasks[i].Undo = nil
+		if n := len(tasks[i].Objects); n > maxStatusObjects {
+			tasks[i].Objects = tasks[i].Objects[n-maxStatusObjects:]
+		}
+	}
+	return tasks
+}
+
+func setCondition(status *WorkflowStatus, generation int64, conditionType string, conditionStatus metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
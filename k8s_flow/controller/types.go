@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/penk110/k8s_operator/k8s_flow"
+)
+
+// WorkflowGVR Workflow 自定义资源，定义见 crd.yaml
+var WorkflowGVR = schema.GroupVersionResource{
+	Group:    "flow.k8s-operator.io",
+	Version:  "v1alpha1",
+	Resource: "workflows",
+}
+
+// WorkflowGVK 设置 ownerReference 时使用
+var WorkflowGVK = WorkflowGVR.GroupVersion().WithKind("Workflow")
+
+const (
+	// DefaultSourceKey configMapRef 未指定 key 时读取的键
+	DefaultSourceKey = "workflow.cue"
+	// TriggeredByAnnotation 写入执行历史的用户，没有时取最后修改 spec 的 field manager
+	TriggeredByAnnotation = "flow.k8s-operator.io/triggered-by"
+)
+
+// WorkflowPhase 工作流执行阶段
+type WorkflowPhase string
+
+const (
+	WorkflowPending   WorkflowPhase = "Pending"
+	WorkflowRunning   WorkflowPhase = "Running"
+	WorkflowSucceeded WorkflowPhase = "Succeeded"
+	WorkflowFailed    WorkflowPhase = "Failed"
+)
+
+const (
+	// ConditionProgressing 工作流正在执行
+	ConditionProgressing = "Progressing"
+	// ConditionSucceeded 当前 generation 执行成功
+	ConditionSucceeded = "Succeeded"
+)
+
+type ConfigMapSourceRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+}
+
+type WorkflowSpec struct {
+	Source       string              `json:"source,omitempty"`
+	ConfigMapRef *ConfigMapSourceRef `json:"configMapRef,omitempty"`
+	Root         string              `json:"root,omitempty"`
+	// Inputs 合并到工作流的 inputs，需要满足工作流声明的 schema
+	Inputs map[string]interface{} `json:"inputs,omitempty"`
+	// Compensation 失败后的补偿模式 never|always|require-approval，缺省 never
+	Compensation string `json:"compensation,omitempty"`
+	// OutputsConfigMap 不为空时执行成功后把 outputs 写入同 namespace 的该 ConfigMap
+	OutputsConfigMap string `json:"outputsConfigMap,omitempty"`
+	// Timeout 整个工作流的超时，如 30m，从 status.startedAt 开始计算，operator 重启后不会重新计时
+	Timeout string `json:"timeout,omitempty"`
+}
+
+type WorkflowStatus struct {
+	ObservedGeneration int64                 `json:"observedGeneration,omitempty"`
+	Phase              WorkflowPhase         `json:"phase,omitempty"`
+	RunID              string                `json:"runID,omitempty"`
+	StartedAt          *metav1.Time          `json:"startedAt,omitempty"`
+	FinishedAt         *metav1.Time          `json:"finishedAt,omitempty"`
+	Message            string                `json:"message,omitempty"`
+	Tasks              []k8s_flow.TaskStatus `json:"tasks,omitempty"`
+	// Outputs 执行成功后工作流 outputs 的值
+	Outputs    json.RawMessage    `json:"outputs,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+type Workflow struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WorkflowSpec   `json:"spec"`
+	Status WorkflowStatus `json:"status,omitempty"`
+}
+
+// finished 当前 generation 是否已经执行结束
+func (w *Workflow) finished() bool {
+	return w.Status.ObservedGeneration == w.Generation &&
+		(w.Status.Phase == WorkflowSucceeded || w.Status.Phase == WorkflowFailed)
+}
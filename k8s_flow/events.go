@@ -0,0 +1,245 @@
+package k8s_flow
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cuelang.org/go/tools/flow"
+)
+
+// EventType 事件类型
+type EventType string
+
+const (
+	// EventTask 节点状态变化
+	EventTask EventType = "task"
+	// EventRun 整个执行开始或结束
+	EventRun EventType = "run"
+)
+
+// 节点事件的状态，Finished、Failed 对应 flow.Terminated 的成功和失败，
+// Cancelled 为执行结束时被取消的节点，flow 不会再通知这些节点的变化
+const (
+	StateWaiting   = "Waiting"
+	StateReady     = "Ready"
+	StateRunning   = "Running"
+	StateFinished  = "Finished"
+	StateFailed    = "Failed"
+	StateCancelled = "Cancelled"
+)
+
+// Event 执行过程中的一个状态变化，Seq 在进程内全局递增，断线重连时用于补发，
+// 执行被重置后新执行的 Seq 也一定大于旧执行的
+type Event struct {
+	Seq   int64     `json:"seq"`
+	RunID string    `json:"runID,omitempty"`
+	Type  EventType `json:"type"`
+	Path  string    `json:"path,omitempty"`
+	Kind  string    `json:"kind,omitempty"`
+	// State 节点事件为 Waiting、Ready、Running、Finished、Failed、Cancelled，
+	// 执行事件为 Running 或最终的 RunPhase
+	State string `json:"state"`
+	// Phase 节点结束时的阶段，区分 Succeeded、Skipped 等
+	Phase TaskPhase `json:"phase,omitempty"`
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+var eventSeq int64
+
+// defaultEventHistory 每个执行保留的历史事件数
+const defaultEventHistory = 1000
+
+// eventBuffer 订阅者 channel 的缓冲，消费太慢时断开订阅，由客户端带上最后的 Seq 重连补发
+const eventBuffer = 256
+
+// EventLog 一个执行的事件日志，保留最近的历史事件并广播给订阅者
+type EventLog struct {
+	mu      sync.Mutex
+	max     int
+	history []Event
+	subs    map[chan Event]struct{}
+	closed  bool
+
+	// flush WatchFlow 发布 flow 节点状态的变化
+	flush func(c *flow.Controller, cancelled bool)
+}
+
+func newEventLog(max int) *EventLog {
+	return &EventLog{max: max, subs: map[chan Event]struct{}{}}
+}
+
+func (l *EventLog) publish(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+	e.Seq = atomic.AddInt64(&eventSeq, 1)
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	l.history = append(l.history, e)
+	if len(l.history) > l.max {
+		l.history = l.history[len(l.history)-l.max:]
+	}
+
+	for ch := range l.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(l.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe 返回 Seq 大于 after 的历史事件和之后事件的 channel，
+// 执行结束或消费太慢时 channel 被关闭，调用返回的函数取消订阅
+func (l *EventLog) Subscribe(after int64) ([]Event, <-chan Event, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var replay []Event
+	for _, e := range l.history {
+		if e.Seq > after {
+			replay = append(replay, e)
+		}
+	}
+
+	ch := make(chan Event, eventBuffer)
+	if l.closed {
+		close(ch)
+		return replay, ch, func() {}
+	}
+	l.subs[ch] = struct{}{}
+	return replay, ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, ok := l.subs[ch]; ok {
+			delete(l.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Closed 执行是否已经结束，结束后不会再有新的事件
+func (l *EventLog) Closed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+// Close 执行结束，关闭所有订阅，之后的订阅只能拿到历史事件
+func (l *EventLog) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for ch := range l.subs {
+		delete(l.subs, ch)
+		close(ch)
+	}
+}
+
+// WatchFlow 给直接使用 flow.New 的工作流挂上事件发布，返回的 EventLog 需要在
+// flow.Controller.Run 结束后 CloseFlow。flow 只在节点结束时通知，观察不到节点开始运行的时刻，
+// 节点失败时 flow 直接退出，不再通知，失败的节点由 CloseFlow 发布
+func WatchFlow(cfg *flow.Config) *EventLog {
+	l := newEventLog(defaultEventHistory)
+	published := map[string]string{}
+	l.flush = func(c *flow.Controller, cancelled bool) {
+		for _, task := range c.Tasks() {
+			path, state := task.Path().String(), flowState(task)
+			if cancelled && (state == StateReady || state == StateRunning) {
+				state = StateCancelled
+			}
+			if published[path] == state {
+				continue
+			}
+			published[path] = state
+			e := Event{Type: EventTask, Path: path, State: state}
+			e.Kind, _ = TaskKindOf(task.Value())
+			if state == StateFailed {
+				e.Error = task.Err().Error()
+			}
+			l.publish(e)
+		}
+	}
+	update := cfg.UpdateFunc
+	cfg.UpdateFunc = func(c *flow.Controller, t *flow.Task) error {
+		// UpdateFunc 只在 flow 的调度协程中调用，published 不需要加锁
+		l.flush(c, false)
+		if update != nil {
+			return update(c, t)
+		}
+		return nil
+	}
+	return l
+}
+
+// CloseFlow WatchFlow 的工作流结束后发布每个节点最后的状态并关闭，
+// 没有结束的节点（工作流被取消或其他节点失败）发布为 Cancelled。只能在 c.Run 返回后调用
+func (l *EventLog) CloseFlow(c *flow.Controller) {
+	if l.flush != nil {
+		l.flush(c, true)
+	}
+	l.Close()
+}
+
+func flowState(t *flow.Task) string {
+	switch t.State() {
+	case flow.Ready:
+		return StateReady
+	case flow.Running:
+		return StateRunning
+	case flow.Terminated:
+		if t.Err() != nil {
+			return StateFailed
+		}
+		return StateFinished
+	}
+	return StateWaiting
+}
+
+// Events 执行的事件日志
+func (r *Run) Events() *EventLog {
+	return r.events
+}
+
+// onUpdate 挂在 flow.Config.UpdateFunc 上，对比每个节点的 flow.State 发布变化。
+// flow 只在初始化和节点结束后调用 UpdateFunc，Running 由 runner 开始时发布
+func (r *Run) onUpdate(c *flow.Controller, _ *flow.Task) error {
+	for _, t := range c.Tasks() {
+		if isOutputsTask(t) {
+			continue
+		}
+		r.publishTask(t.Path().String(), flowState(t), t.Err())
+	}
+	return nil
+}
+
+// publishTask 节点状态和上次发布的不同时才发布
+func (r *Run) publishTask(path, state string, err error) {
+	r.mu.Lock()
+	if r.published[path] == state {
+		r.mu.Unlock()
+		return
+	}
+	r.published[path] = state
+	e := Event{RunID: r.id, Type: EventTask, Path: path, State: state}
+	if status, ok := r.tasks[path]; ok {
+		e.Kind = status.Kind
+		if state == StateFinished || state == StateFailed || state == StateCancelled {
+			e.Phase = status.Phase
+		}
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	// 在锁内发布，保证同一个节点的事件顺序
+	r.events.publish(e)
+	r.mu.Unlock()
+}
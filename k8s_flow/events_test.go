@@ -0,0 +1,128 @@
+package k8s_flow
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/tools/flow"
+)
+
+func TestEventLogReplay(t *testing.T) {
+	l := newEventLog(3)
+	for _, path := range []string{"a", "b", "c", "d"} {
+		l.publish(Event{Type: EventTask, Path: path, State: StateFinished})
+	}
+
+	// 只保留最近的 max 个事件
+	all, _, cancel := l.Subscribe(0)
+	cancel()
+	if len(all) != 3 || all[0].Path != "b" || all[2].Path != "d" {
+		t.Fatalf("history = %+v, want b, c, d", all)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Seq <= all[i-1].Seq {
+			t.Errorf("seq is not increasing: %+v", all)
+		}
+		if all[i].Time.IsZero() {
+			t.Errorf("event %d has no time", i)
+		}
+	}
+
+	// 断线重连时只补发 Seq 之后的事件
+	replay, _, cancel := l.Subscribe(all[1].Seq)
+	cancel()
+	if len(replay) != 1 || replay[0].Path != "d" {
+		t.Errorf("replay = %+v, want d", replay)
+	}
+}
+
+func TestEventLogSubscribe(t *testing.T) {
+	l := newEventLog(defaultEventHistory)
+	replay, ch, cancel := l.Subscribe(0)
+	defer cancel()
+	if len(replay) != 0 {
+		t.Fatalf("replay = %+v, want none", replay)
+	}
+
+	l.publish(Event{Type: EventRun, State: StateRunning})
+	if e := <-ch; e.Type != EventRun || e.State != StateRunning {
+		t.Errorf("event = %+v", e)
+	}
+
+	// 结束后关闭订阅，之后的订阅只能拿到历史事件
+	l.Close()
+	if _, ok := <-ch; ok {
+		t.Error("channel should be closed after Close")
+	}
+	if !l.Closed() {
+		t.Error("Closed() = false after Close")
+	}
+	l.publish(Event{Type: EventRun, State: "Succeeded"})
+	replay, ch, _ = l.Subscribe(0)
+	if len(replay) != 1 {
+		t.Errorf("replay = %+v, want only the event before Close", replay)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("subscribing to a closed log should return a closed channel")
+	}
+}
+
+func TestEventLogSlowSubscriber(t *testing.T) {
+	l := newEventLog(defaultEventHistory)
+	_, ch, cancel := l.Subscribe(0)
+	for i := 0; i <= eventBuffer; i++ {
+		l.publish(Event{Type: EventTask, Path: "a", State: StateRunning})
+	}
+
+	// 缓冲满后断开订阅，已经缓冲的事件仍然可以读到
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != eventBuffer {
+		t.Errorf("received %d events, want %d", n, eventBuffer)
+	}
+	// 已经断开的订阅再取消不会重复 close
+	cancel()
+}
+
+func TestWatchFlow(t *testing.T) {
+	v := cuecontext.New().CompileString(`
+a: {$task: "sleep", duration: "0s"}
+b: {$task: "sleep", duration: a.duration, fail: true}
+`)
+	cfg := &flow.Config{}
+	l := WatchFlow(cfg)
+	c := flow.New(cfg, v, func(v cue.Value) (flow.Runner, error) {
+		kind, err := TaskKindOf(v)
+		if err != nil || kind == "" {
+			return nil, err
+		}
+		return flow.RunnerFunc(func(t *flow.Task) error {
+			if fail, _ := t.Value().LookupPath(cue.ParsePath("fail")).Bool(); fail {
+				return errors.New("boom")
+			}
+			return nil
+		}), nil
+	})
+	_ = c.Run(context.Background())
+	l.CloseFlow(c)
+
+	events, _, _ := l.Subscribe(0)
+	last := map[string]Event{}
+	for _, e := range events {
+		if e.Kind != "sleep" {
+			t.Errorf("event %+v: kind = %q, want sleep", e, e.Kind)
+		}
+		last[e.Path] = e
+	}
+	if e := last["a"]; e.State != StateFinished {
+		t.Errorf("a = %+v, want %s", e, StateFinished)
+	}
+	if e := last["b"]; e.State != StateFailed || e.Error == "" {
+		t.Errorf("b = %+v, want %s with an error", e, StateFailed)
+	}
+}
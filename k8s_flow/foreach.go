@@ -0,0 +1,144 @@
+package k8s_flow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"cuelang.org/go/cue"
+	"k8s.io/klog/v2"
+)
+
+// ForEachTask 运行时按 items 展开成多个子节点，子节点由 task 模板生成，
+// 模板中通过 item、index 引用当前元素，例如
+//
+//	netpol: {
+//		$task:       "foreach"
+//		items:       namespaces.items
+//		parallelism: 5
+//		task: {
+//			item:   _
+//			$task:  "apply"
+//			object: {
+//				apiVersion: "networking.k8s.io/v1"
+//				kind:       "NetworkPolicy"
+//				metadata: {name: "default-deny", namespace: item.metadata.name}
+//				spec: {podSelector: {}, policyTypes: ["Ingress"]}
+//			}
+//		}
+//	}
+var ForEachTask = &TaskKind{
+	Name: "foreach",
+	Doc: `输入: items 列表, 可以引用上游节点的输出; task 子节点模板, 通过 item、index 引用当前元素;
+parallelism 并发数, 缺省 1; failFast 有子节点失败时不再启动新的子节点, 缺省 true
+输出: results 与 items 一一对应的子节点输出, 跳过的子节点为 {}`,
+	Schema: `
+items: [...]
+task: {
+	item:  _
+	index: int
+	...
+}
+parallelism: *1 | int & >=1
+failFast:    *true | bool
+`,
+	Run: func(ctx context.Context, v cue.Value) (interface{}, error) {
+		items, err := v.LookupPath(cue.ParsePath("items")).List()
+		if err != nil {
+			return nil, err
+		}
+		parallelism, err := v.LookupPath(cue.ParsePath("parallelism")).Int64()
+		if err != nil {
+			return nil, err
+		}
+		failFast, err := lookupBool(v, "failFast")
+		if err != nil {
+			return nil, err
+		}
+		tpl := v.LookupPath(cue.ParsePath("task"))
+
+		var children []cue.Value
+		for i := 0; items.Next(); i++ {
+			child := tpl.FillPath(cue.ParsePath("item"), items.Value()).
+				FillPath(cue.ParsePath("index"), i)
+			children = append(children, child)
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			errs    []string
+			sem     = make(chan struct{}, parallelism)
+			results = make([]interface{}, len(children))
+		)
+		for i, child := range children {
+			results[i] = map[string]interface{}{}
+
+			sem <- struct{}{}
+			if failFast && ctx.Err() != nil {
+				<-sem
+				break
+			}
+			wg.Add(1)
+			go func(i int, child cue.Value) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				out, err := runChild(ctx, child)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					klog.Errorf("foreach child %d failed: %v", i, err)
+					errs = append(errs, fmt.Sprintf("[%d] %v", i, err))
+					if failFast {
+						cancel()
+					}
+					return
+				}
+				if out != nil {
+					results[i] = out
+				}
+			}(i, child)
+		}
+		wg.Wait()
+
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("%d of %d children failed:\n%s", len(errs), len(children), strings.Join(errs, "\n"))
+		}
+		return map[string]interface{}{"results": results}, nil
+	},
+}
+
+// runChild 执行一个展开后的子节点，子节点同样支持 when、retry、timeout
+func runChild(ctx context.Context, child cue.Value) (interface{}, error) {
+	name, err := TaskKindOf(child)
+	if err != nil {
+		return nil, err
+	}
+	kind, ok := LookupTaskKind(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown task kind %q", name)
+	}
+
+	in, err := kind.input(child)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := evalWhen(in); err != nil || !ok {
+		return nil, err
+	}
+	policy, err := parseRetryPolicy(in)
+	if err != nil {
+		return nil, err
+	}
+
+	return runWithRetry(ctx, policy, func(Attempt) {}, func(ctx context.Context) (interface{}, error) {
+		return kind.Run(ctx, in)
+	})
+}
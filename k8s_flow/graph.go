@@ -0,0 +1,172 @@
+package k8s_flow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/tools/flow"
+)
+
+// GraphNode 依赖图中的一个节点
+type GraphNode struct {
+	ID    string `json:"id"`
+	Path  string `json:"path"`
+	Kind  string `json:"kind,omitempty"`
+	State string `json:"state"`
+}
+
+// GraphEdge From 依赖 To 的输出，To 先于 From 执行
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Graph 工作流节点的依赖图，来自 flow.Controller 的 Tasks 和每个节点的 Dependencies
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// graphTask 构建依赖图需要的节点信息，flow.Controller 运行时内部状态没有加锁，不能并发读取
+type graphTask struct {
+	id   string
+	path string
+	kind string
+	deps []string
+	// state flow 的状态，Run 中使用节点的 TaskPhase
+	state string
+}
+
+// graphTasks 在 flow.Controller 没有运行时读取节点和依赖
+func graphTasks(c *flow.Controller) []graphTask {
+	var tasks []graphTask
+	for _, t := range c.Tasks() {
+		if isOutputsTask(t) {
+			continue
+		}
+		kind, _ := TaskKindOf(t.Value())
+		gt := graphTask{id: fmt.Sprintf("t%d", t.Index()), path: t.Path().String(), kind: kind, state: t.State().String()}
+		for _, dep := range t.Dependencies() {
+			gt.deps = append(gt.deps, fmt.Sprintf("t%d", dep.Index()))
+		}
+		tasks = append(tasks, gt)
+	}
+	return tasks
+}
+
+// GraphOf 任意 flow.Controller 的依赖图，节点类型来自 $task/@task 声明，状态为 flow.State，
+// 不能在 c 运行时调用
+func GraphOf(c *flow.Controller) *Graph {
+	return newGraph(graphTasks(c), func(t graphTask) string {
+		return t.state
+	})
+}
+
+// Graph 当前执行的依赖图，节点和依赖来自 NewRun 时的快照，状态为节点的 TaskPhase
+func (r *Run) Graph() *Graph {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return newGraph(r.graph, func(t graphTask) string {
+		if status, ok := r.tasks[t.path]; ok {
+			return string(status.Phase)
+		}
+		return string(TaskPending)
+	})
+}
+
+func newGraph(tasks []graphTask, state func(t graphTask) string) *Graph {
+	g := &Graph{}
+	for _, t := range tasks {
+		g.Nodes = append(g.Nodes, GraphNode{
+			ID:    t.id,
+			Path:  t.path,
+			Kind:  t.kind,
+			State: state(t),
+		})
+		for _, dep := range t.deps {
+			g.Edges = append(g.Edges, GraphEdge{From: t.id, To: dep})
+		}
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+	return g
+}
+
+// stateColors 各状态的填充色，flow.State 和 TaskPhase 共用
+var stateColors = map[string]string{
+	"Waiting":   "#eeeeee",
+	"Pending":   "#eeeeee",
+	"Ready":     "#d6e9f8",
+	"Running":   "#fff3b0",
+	"Succeeded": "#c8e6c9",
+	// flow.State 的 Terminated 不区分成功失败
+	"Terminated":  "#c8e6c9",
+	"Failed":      "#ffcdd2",
+	"Skipped":     "#cfd8dc",
+	"Compensated": "#e1bee7",
+	"Cancelled":   "#ffe0b2",
+}
+
+func (n GraphNode) label(sep string) string {
+	label := n.Path
+	if n.Kind != "" {
+		label += sep + n.Kind
+	}
+	return label + sep + n.State
+}
+
+// DOT Graphviz 格式，箭头从上游指向下游，表示执行顺序
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph workflow {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "  %s [label=%s, fillcolor=%q];\n", n.ID, dotQuote(n.label("\n")), stateColor(n.State))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "  %s -> %s;\n", e.To, e.From)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid flowchart 格式，可以直接嵌入页面由 mermaid.js 渲染
+func (g *Graph) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "  %s[\"%s\"]\n", n.ID, mermaidEscape(n.label("<br/>")))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "  %s --> %s\n", e.To, e.From)
+	}
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "  style %s fill:%s\n", n.ID, stateColor(n.State))
+	}
+	return b.String()
+}
+
+func stateColor(state string) string {
+	if color, ok := stateColors[state]; ok {
+		return color
+	}
+	return "#ffffff"
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+func mermaidEscape(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
@@ -0,0 +1,424 @@
+package k8s_flow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/klog/v2"
+)
+
+// 节点修改对象的方式
+const (
+	ObjectCreated = "created"
+	ObjectUpdated = "updated"
+	ObjectDeleted = "deleted"
+	ObjectPatched = "patched"
+	ObjectScaled  = "scaled"
+)
+
+// ObjectChange 节点修改过的集群对象，记录在节点状态和执行历史中
+type ObjectChange struct {
+	Action     string `json:"action"`
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	// ResourceVersion 修改后的版本，删除时为空
+	ResourceVersion string    `json:"resourceVersion,omitempty"`
+	Time            time.Time `json:"time"`
+}
+
+func (c ObjectChange) String() string {
+	if c.Namespace == "" {
+		return fmt.Sprintf("%s %s", c.Kind, c.Name)
+	}
+	return fmt.Sprintf("%s %s/%s", c.Kind, c.Namespace, c.Name)
+}
+
+// matches object 为 kind/name 或 kind/namespace/name，kind 不区分大小写
+func (c ObjectChange) matches(object string) bool {
+	parts := strings.Split(object, "/")
+	if len(parts) < 2 || len(parts) > 3 || !strings.EqualFold(parts[0], c.Kind) {
+		return false
+	}
+	if len(parts) == 3 {
+		return parts[1] == c.Namespace && parts[2] == c.Name
+	}
+	return parts[1] == c.Name
+}
+
+type objectsKey struct{}
+
+func withObjects(ctx context.Context, record func(ObjectChange)) context.Context {
+	return context.WithValue(ctx, objectsKey{}, record)
+}
+
+// RecordObject 节点类型在 Run 中修改集群对象后调用，用于审计谁在哪次执行中修改了哪个对象，没有通过 Run 执行时忽略
+func RecordObject(ctx context.Context, action string, obj *unstructured.Unstructured) {
+	record, ok := ctx.Value(objectsKey{}).(func(ObjectChange))
+	if !ok || obj == nil {
+		return
+	}
+	record(ObjectChange{
+		Action:          action,
+		APIVersion:      obj.GetAPIVersion(),
+		Kind:            obj.GetKind(),
+		Namespace:       obj.GetNamespace(),
+		Name:            obj.GetName(),
+		ResourceVersion: obj.GetResourceVersion(),
+		Time:            time.Now(),
+	})
+}
+
+// RunMeta 执行的来源，写入执行历史
+type RunMeta struct {
+	// Workflow 模板名、Workflow 资源的 namespace/name 或去掉扩展名的文件名
+	Workflow string
+	// User 发起执行的用户
+	User string
+	// Trigger 发起方式，如 cli、api、operator
+	Trigger string
+	// Params 执行的参数，只记录 hash
+	Params map[string]interface{}
+}
+
+// WithHistory 执行开始、节点状态变化和结束时把审计记录写入 store
+func WithHistory(store HistoryStore, meta RunMeta) RunOption {
+	return func(r *Run) {
+		r.history = store
+		r.meta = meta
+	}
+}
+
+// RunRecord 一次执行的审计记录，恢复执行和重新开始时 RunID 不变，每次执行有自己的 ID
+type RunRecord struct {
+	ID       string `json:"id"`
+	RunID    string `json:"runID,omitempty"`
+	Workflow string `json:"workflow"`
+	User     string `json:"user,omitempty"`
+	Trigger  string `json:"trigger,omitempty"`
+	// InputHash 合并参数后整个工作流的 hash，ParamsHash 只包含参数
+	InputHash  string       `json:"inputHash"`
+	ParamsHash string       `json:"paramsHash,omitempty"`
+	Phase      TaskPhase    `json:"phase"`
+	Error      string       `json:"error,omitempty"`
+	StartedAt  time.Time    `json:"startedAt"`
+	FinishedAt time.Time    `json:"finishedAt,omitempty"`
+	Tasks      []TaskRecord `json:"tasks,omitempty"`
+}
+
+// TaskRecord 审计记录中的节点，不包含输出和撤销信息，复用之前结果的节点不包含 Objects
+type TaskRecord struct {
+	Path       string         `json:"path"`
+	Kind       string         `json:"kind"`
+	Phase      TaskPhase      `json:"phase"`
+	Attempts   []Attempt      `json:"attempts,omitempty"`
+	StartedAt  time.Time      `json:"startedAt,omitempty"`
+	FinishedAt time.Time      `json:"finishedAt,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	SkipReason string         `json:"skipReason,omitempty"`
+	Resumed    bool           `json:"resumed,omitempty"`
+	Objects    []ObjectChange `json:"objects,omitempty"`
+}
+
+// Objects 所有节点修改过的对象
+func (rec *RunRecord) Objects() []ObjectChange {
+	var objects []ObjectChange
+	for _, t := range rec.Tasks {
+		objects = append(objects, t.Objects...)
+	}
+	return objects
+}
+
+// HistoryFilter 查询执行历史的条件，零值的字段不过滤
+type HistoryFilter struct {
+	Workflow string
+	User     string
+	Phase    TaskPhase
+	// Object 修改过的对象，kind/name 或 kind/namespace/name，如 deployment/default/flowdeploy
+	Object string
+	// Since、Until 执行开始时间的范围
+	Since time.Time
+	Until time.Time
+	// Limit 最多返回的记录数，按开始时间倒序
+	Limit int
+}
+
+// Match rec 是否满足条件
+func (f HistoryFilter) Match(rec *RunRecord) bool {
+	switch {
+	case f.Workflow != "" && rec.Workflow != f.Workflow:
+		return false
+	case f.User != "" && rec.User != f.User:
+		return false
+	case f.Phase != "" && rec.Phase != f.Phase:
+		return false
+	case !f.Since.IsZero() && rec.StartedAt.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && rec.StartedAt.After(f.Until):
+		return false
+	}
+	if f.Object == "" {
+		return true
+	}
+	for _, c := range rec.Objects() {
+		if c.matches(f.Object) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseHistoryTime 解析查询的时间，支持 RFC3339、2006-01-02 和表示之前多久的时长，如 24h
+func ParseHistoryTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339, 2006-01-02 or a duration like 24h", s)
+}
+
+// HistoryStore 执行历史的存储后端，Get 在记录不存在时返回 nil, nil
+type HistoryStore interface {
+	Record(ctx context.Context, rec *RunRecord) error
+	Get(ctx context.Context, id string) (*RunRecord, error)
+	List(ctx context.Context, filter HistoryFilter) ([]RunRecord, error)
+}
+
+// DefaultHistoryMaxAge、DefaultHistoryMaxRuns FileHistory 缺省的保留策略
+const (
+	DefaultHistoryMaxAge  = 30 * 24 * time.Hour
+	DefaultHistoryMaxRuns = 1000
+)
+
+// FileHistory 本地目录中的执行历史，每个执行一个 json 文件，
+// 执行结束时按 MaxAge、MaxRuns 清理最早的记录，为 0 时不限制。只支持单进程写入，可以多进程读取
+type FileHistory struct {
+	Dir     string
+	MaxAge  time.Duration
+	MaxRuns int
+
+	mu sync.Mutex
+}
+
+func NewFileHistory(dir string) (*FileHistory, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileHistory{Dir: dir, MaxAge: DefaultHistoryMaxAge, MaxRuns: DefaultHistoryMaxRuns}, nil
+}
+
+func (h *FileHistory) path(id string) string {
+	return filepath.Join(h.Dir, id+".json")
+}
+
+func (h *FileHistory) Record(ctx context.Context, rec *RunRecord) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err = writeFile(h.path(rec.ID), data); err != nil {
+		return err
+	}
+	if rec.FinishedAt.IsZero() {
+		return nil
+	}
+	return h.prune()
+}
+
+// prune 按文件的修改时间（即最后一次写入）清理过期和超出数量的记录
+func (h *FileHistory) prune() error {
+	entries, err := h.files()
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime().After(entries[j].ModTime()) })
+	for i, e := range entries {
+		expired := h.MaxAge > 0 && time.Since(e.ModTime()) > h.MaxAge
+		if expired || (h.MaxRuns > 0 && i >= h.MaxRuns) {
+			if err := os.Remove(filepath.Join(h.Dir, e.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// files 目录下的记录文件，跳过写入中的临时文件
+func (h *FileHistory) files() ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(h.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			// 已经被清理
+			continue
+		}
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+func (h *FileHistory) Get(ctx context.Context, id string) (*RunRecord, error) {
+	// id 可能来自请求，不能跳出目录
+	if id == "" || filepath.Base(id) != id || id == ".." {
+		return nil, nil
+	}
+	data, err := os.ReadFile(h.path(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec := &RunRecord{}
+	if err = json.Unmarshal(data, rec); err != nil {
+		return nil, fmt.Errorf("decode run record %s: %v", id, err)
+	}
+	return rec, nil
+}
+
+// List 读取所有记录后过滤，按开始时间倒序
+func (h *FileHistory) List(ctx context.Context, filter HistoryFilter) ([]RunRecord, error) {
+	files, err := h.files()
+	if err != nil {
+		return nil, err
+	}
+	records := []RunRecord{}
+	for _, f := range files {
+		rec, err := h.Get(ctx, strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if rec != nil && filter.Match(rec) {
+			records = append(records, *rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].StartedAt.After(records[j].StartedAt) })
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[:filter.Limit]
+	}
+	return records, nil
+}
+
+// hashParams 参数的 hash，json 编码时 map 的键是有序的
+func hashParams(params map[string]interface{}) string {
+	if len(params) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// begins 记录执行开始，没有执行 ID 时用工作流名生成
+func (r *Run) begins(ctx context.Context) {
+	r.mu.Lock()
+	r.phase = TaskRunning
+	r.startedAt = time.Now()
+	if r.history != nil {
+		base := r.id
+		if base == "" {
+			base = r.meta.Workflow
+		}
+		if base == "" {
+			base = "run"
+		}
+		r.recordID = fmt.Sprintf("%s-%s", base, rand.String(5))
+	}
+	r.mu.Unlock()
+	// 还没有加载之前的状态，不能用 save 覆盖
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), saveTimeout)
+	defer cancel()
+	r.record(ctx)
+}
+
+// ends 记录执行结果
+func (r *Run) ends(ctx context.Context, phase TaskPhase, runErr string) {
+	r.mu.Lock()
+	r.phase = phase
+	r.runErr = runErr
+	r.finishedAt = time.Now()
+	r.mu.Unlock()
+	r.save(ctx)
+}
+
+// RecordID 本次执行在历史中的 ID，没有配置 WithHistory 或还没开始时为空
+func (r *Run) RecordID() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.recordID
+}
+
+// record 写入当前的审计记录，失败只记录日志，ctx 由 save 设置超时
+func (r *Run) record(ctx context.Context) {
+	if r.history == nil {
+		return
+	}
+	r.mu.RLock()
+	rec := &RunRecord{
+		ID:         r.recordID,
+		RunID:      r.id,
+		Workflow:   r.meta.Workflow,
+		User:       r.meta.User,
+		Trigger:    r.meta.Trigger,
+		InputHash:  r.inputHash,
+		ParamsHash: hashParams(r.meta.Params),
+		Phase:      r.phase,
+		Error:      r.runErr,
+		StartedAt:  r.startedAt,
+		FinishedAt: r.finishedAt,
+	}
+	r.mu.RUnlock()
+	if rec.ID == "" {
+		return
+	}
+	for _, t := range r.Tasks() {
+		task := TaskRecord{
+			Path:       t.Path,
+			Kind:       t.Kind,
+			Phase:      t.Phase,
+			Attempts:   t.Attempts,
+			StartedAt:  t.StartedAt,
+			FinishedAt: t.FinishedAt,
+			Error:      t.Error,
+			SkipReason: t.SkipReason,
+			Resumed:    t.Resumed,
+		}
+		if !t.Resumed {
+			task.Objects = t.Objects
+		}
+		rec.Tasks = append(rec.Tasks, task)
+	}
+	if err := r.history.Record(ctx, rec); err != nil {
+		klog.Errorf("run %s: record history failed: %v", rec.ID, err)
+	}
+}
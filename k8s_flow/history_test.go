@@ -0,0 +1,201 @@
+package k8s_flow
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func historyRecord(id string, started time.Time) *RunRecord {
+	return &RunRecord{
+		ID:        id,
+		Workflow:  "deploy",
+		User:      "alice",
+		Phase:     TaskSucceeded,
+		StartedAt: started,
+		Tasks: []TaskRecord{{
+			Path:  "step1",
+			Kind:  "apply",
+			Phase: TaskSucceeded,
+			Objects: []ObjectChange{
+				{Action: ObjectCreated, APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "flowdeploy"},
+			},
+		}, {
+			Path:  "step2",
+			Kind:  "apply",
+			Phase: TaskSucceeded,
+			Objects: []ObjectChange{
+				{Action: ObjectUpdated, APIVersion: "v1", Kind: "Namespace", Name: "demo"},
+			},
+		}},
+	}
+}
+
+func TestHistoryFilterMatch(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rec := historyRecord("r1", now)
+	tests := []struct {
+		name   string
+		filter HistoryFilter
+		want   bool
+	}{
+		{"empty", HistoryFilter{}, true},
+		{"workflow", HistoryFilter{Workflow: "deploy"}, true},
+		{"other workflow", HistoryFilter{Workflow: "cleanup"}, false},
+		{"user", HistoryFilter{User: "alice"}, true},
+		{"other user", HistoryFilter{User: "bob"}, false},
+		{"phase", HistoryFilter{Phase: TaskSucceeded}, true},
+		{"other phase", HistoryFilter{Phase: TaskFailed}, false},
+		{"since", HistoryFilter{Since: now.Add(-time.Hour)}, true},
+		{"since later", HistoryFilter{Since: now.Add(time.Hour)}, false},
+		{"until", HistoryFilter{Until: now.Add(time.Hour)}, true},
+		{"until earlier", HistoryFilter{Until: now.Add(-time.Hour)}, false},
+		{"namespaced object", HistoryFilter{Object: "deployment/default/flowdeploy"}, true},
+		{"object without namespace", HistoryFilter{Object: "Deployment/flowdeploy"}, true},
+		{"cluster object", HistoryFilter{Object: "namespace/demo"}, true},
+		{"object in other namespace", HistoryFilter{Object: "deployment/kube-system/flowdeploy"}, false},
+		{"other kind", HistoryFilter{Object: "service/flowdeploy"}, false},
+		{"invalid object", HistoryFilter{Object: "flowdeploy"}, false},
+		{"all", HistoryFilter{Workflow: "deploy", User: "alice", Phase: TaskSucceeded, Object: "deployment/flowdeploy"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(rec); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"24h", now.Add(-24 * time.Hour)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"2024-04-30T08:00:00Z", time.Date(2024, 4, 30, 8, 0, 0, 0, time.UTC)},
+		{"2024-04-30", time.Date(2024, 4, 30, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := ParseHistoryTime(tt.in, now)
+		if err != nil {
+			t.Errorf("ParseHistoryTime(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseHistoryTime(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{"", "yesterday", "2024/04/30"} {
+		if _, err := ParseHistoryTime(in, now); err == nil {
+			t.Errorf("ParseHistoryTime(%q): expected an error", in)
+		}
+	}
+}
+
+func TestFileHistory(t *testing.T) {
+	ctx := context.Background()
+	h, err := NewFileHistory(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		rec := historyRecord(fmt.Sprintf("r%d", i), start.Add(time.Duration(i)*time.Minute))
+		if i == 1 {
+			rec.User = "bob"
+		}
+		if err := h.Record(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec, err := h.Get(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := historyRecord("r1", start.Add(time.Minute)); rec == nil || rec.User != "bob" || !reflect.DeepEqual(rec.Tasks, want.Tasks) {
+		t.Errorf("Get(r1) = %+v", rec)
+	}
+	// 不存在的记录和跳出目录的 id 返回 nil
+	for _, id := range []string{"missing", "", "..", "../r1", "a/b"} {
+		if rec, err := h.Get(ctx, id); rec != nil || err != nil {
+			t.Errorf("Get(%q) = %+v, %v, want nil", id, rec, err)
+		}
+	}
+
+	records, err := h.List(ctx, HistoryFilter{User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 按开始时间倒序
+	if len(records) != 2 || records[0].ID != "r2" || records[1].ID != "r0" {
+		t.Errorf("List = %+v, want r2, r0", records)
+	}
+	if records, _ = h.List(ctx, HistoryFilter{Limit: 1}); len(records) != 1 || records[0].ID != "r2" {
+		t.Errorf("List with limit = %+v, want r2", records)
+	}
+}
+
+func TestFileHistoryPrune(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	h := &FileHistory{Dir: dir, MaxAge: 24 * time.Hour, MaxRuns: 3}
+
+	now := time.Now()
+	write := func(id string, modified time.Time) {
+		rec := historyRecord(id, modified)
+		if err := h.Record(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(h.path(id), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("expired", now.Add(-48*time.Hour))
+	write("old", now.Add(-3*time.Hour))
+	write("r1", now.Add(-2*time.Hour))
+	write("r2", now.Add(-time.Hour))
+	// 不是记录的文件不清理
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 执行结束时清理，保留最近的 MaxRuns 个
+	rec := historyRecord("r3", now)
+	rec.FinishedAt = now
+	if err := h.Record(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{"notes.txt", "r1.json", "r2.json", "r3.json"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("files = %v, want %v", names, want)
+	}
+}
+
+func TestHashParams(t *testing.T) {
+	a := hashParams(map[string]interface{}{"app": "demo", "replicas": 2})
+	b := hashParams(map[string]interface{}{"replicas": 2, "app": "demo"})
+	if a == "" || a != b {
+		t.Errorf("hashParams is not stable: %q, %q", a, b)
+	}
+	if hashParams(nil) != "" {
+		t.Error("hashParams(nil) should be empty")
+	}
+}
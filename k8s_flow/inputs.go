@@ -0,0 +1,244 @@
+package k8s_flow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	cueerrors "cuelang.org/go/cue/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// InputsPath 工作流声明参数 schema 的路径，调用方提供的参数合并到这里：
+//
+//	inputs: {
+//		app:      string
+//		replicas: *1 | int & >0
+//	}
+//	step1: object: spec: replicas: inputs.replicas
+const InputsPath = "inputs"
+
+// Inputs 调用方提供的参数，实现了 flag.Value，可以作为重复的 -p key=value 参数：
+//
+//	inputs := k8s_flow.Inputs{}
+//	flag.Var(inputs, "p", "workflow input key=value")
+//
+// 从 JSON、YAML 解码时整数保持为 int64，不会变成 float64，否则合并到 int 字段时类型冲突
+type Inputs map[string]interface{}
+
+// Set 解析 key=value，key 可以是 a.b.c 形式的嵌套路径，
+// value 能按 JSON 解析时取解析结果（数字、布尔、列表等），否则作为字符串。
+// 数字、布尔在 ApplyInputs 中对应的字段只能是字符串时仍然取原始文本，如 -p tag=1.20
+func (in Inputs) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("input %q: expected key=value", s)
+	}
+	key, raw := s[:i], s[i+1:]
+
+	var value interface{} = raw
+	if decoded, err := decodeJSON([]byte(raw)); err == nil {
+		switch decoded.(type) {
+		case string, map[string]interface{}, []interface{}:
+			value = decoded
+		default:
+			value = flagInput{raw: raw, value: decoded}
+		}
+	}
+	return in.setPath(strings.Split(key, "."), value)
+}
+
+// flagInput -p 传入的数字、布尔、null，保留原始文本，由 ApplyInputs 按 schema 决定取哪个
+type flagInput struct {
+	raw   string
+	value interface{}
+}
+
+func (f flagInput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.value)
+}
+
+// UnmarshalJSON 顶层必须是对象，整数解码为 int64，REST API 的请求体和参数文件都通过它解码
+func (in *Inputs) UnmarshalJSON(data []byte) error {
+	v, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		// 空文件或 null 不修改已有的参数
+		return nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("inputs must be an object")
+	}
+	*in = m
+	return nil
+}
+
+// decodeJSON 解码 JSON，整数为 int64，其他数字为 float64
+func decodeJSON(data []byte) (interface{}, error) {
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("invalid json")
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return convertNumbers(v), nil
+}
+
+func convertNumbers(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i
+		}
+		f, _ := x.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range x {
+			x[k] = convertNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range x {
+			x[i] = convertNumbers(item)
+		}
+	}
+	return v
+}
+
+func (in Inputs) setPath(keys []string, value interface{}) error {
+	m := map[string]interface{}(in)
+	for i, key := range keys[:len(keys)-1] {
+		next, ok := m[key]
+		if !ok {
+			child := map[string]interface{}{}
+			m[key], m = child, child
+			continue
+		}
+		child, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("input %s is not an object", strings.Join(keys[:i+1], "."))
+		}
+		m = child
+	}
+	m[keys[len(keys)-1]] = value
+	return nil
+}
+
+func (in Inputs) String() string {
+	keys := make([]string, 0, len(in))
+	for k := range in {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// Merge 合并 other，同名字段是对象时逐层合并，否则 other 覆盖
+func (in Inputs) Merge(other map[string]interface{}) {
+	mergeInto(in, other)
+}
+
+func mergeInto(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sub, ok := v.(map[string]interface{})
+		if cur, isMap := dst[k].(map[string]interface{}); ok && isMap {
+			mergeInto(cur, sub)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// LoadInputsFile 读取 JSON 或 YAML 格式的参数文件，顶层必须是对象，整数解码为 int64
+func LoadInputsFile(path string) (Inputs, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	in := Inputs{}
+	// JSON 是 YAML 的子集，统一按 YAML 解析
+	if err := yaml.Unmarshal(data, &in); err != nil {
+		return nil, fmt.Errorf("parse inputs file %s: %v", path, err)
+	}
+	return in, nil
+}
+
+// InputError 参数不满足工作流声明的 inputs schema，Err 是 cue 的校验错误，带有出错的位置
+type InputError struct {
+	Err error
+}
+
+func (e *InputError) Error() string {
+	return "invalid inputs:\n" + strings.TrimSpace(cueerrors.Details(e.Err, nil))
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// Details 每个校验错误一行，格式为 位置: 错误信息
+func (e *InputError) Details() []string {
+	var details []string
+	for _, err := range cueerrors.Errors(e.Err) {
+		details = append(details, strings.TrimSpace(cueerrors.Details(err, nil)))
+	}
+	return details
+}
+
+// ResolveInputs 按工作流声明的 inputs schema 确定 -p 传入的值：字段只能是字符串时取原始文本，
+// 否则取 JSON 解析的结果，返回新的 map，不修改 inputs
+func ResolveInputs(v cue.Value, inputs map[string]interface{}) map[string]interface{} {
+	resolved, _ := resolveInput(v.LookupPath(cue.ParsePath(InputsPath)), inputs).(map[string]interface{})
+	return resolved
+}
+
+func resolveInput(schema cue.Value, v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, item := range x {
+			out[k] = resolveInput(schema.LookupPath(cue.MakePath(cue.Str(k))), item)
+		}
+		return out
+	case flagInput:
+		if schema.Exists() && schema.IncompleteKind() == cue.StringKind {
+			return x.raw
+		}
+		return x.value
+	}
+	return v
+}
+
+// ApplyInputs 在 flow.New 之前把 inputs 合并到工作流的 InputsPath，
+// 并要求合并后的 inputs 是具体的值，缺少必填参数或类型不匹配时返回 *InputError
+func ApplyInputs(v cue.Value, inputs map[string]interface{}) (cue.Value, error) {
+	path := cue.ParsePath(InputsPath)
+	schema := v.LookupPath(path)
+	if !schema.Exists() {
+		if len(inputs) > 0 {
+			return v, fmt.Errorf("workflow declares no %s, but inputs were given", InputsPath)
+		}
+		return v, nil
+	}
+
+	if len(inputs) > 0 {
+		v = v.FillPath(path, ResolveInputs(v, inputs))
+	}
+	if err := v.LookupPath(path).Validate(cue.Concrete(true)); err != nil {
+		return v, &InputError{Err: err}
+	}
+	// 参数可能通过引用影响工作流的其他部分
+	if err := v.Validate(); err != nil {
+		return v, &InputError{Err: err}
+	}
+	return v, nil
+}
@@ -0,0 +1,227 @@
+package k8s_flow
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+)
+
+func TestInputsSet(t *testing.T) {
+	in := Inputs{}
+	for _, s := range []string{
+		"app=demo",
+		"replicas=3",
+		"debug=true",
+		"ports=[80,443]",
+		"image.tag=v1.2",
+		"image.repo=nginx",
+		"note=a=b",
+		"version=",
+	} {
+		if err := in.Set(s); err != nil {
+			t.Fatalf("Set(%q): %v", s, err)
+		}
+	}
+	want := Inputs{
+		"app":      "demo",
+		"replicas": flagInput{raw: "3", value: int64(3)},
+		"debug":    flagInput{raw: "true", value: true},
+		"ports":    []interface{}{int64(80), int64(443)},
+		"image":    map[string]interface{}{"tag": "v1.2", "repo": "nginx"},
+		"note":     "a=b",
+		"version":  "",
+	}
+	if !reflect.DeepEqual(in, want) {
+		t.Errorf("inputs = %#v, want %#v", in, want)
+	}
+	if got := in.String(); got != "app,debug,image,note,ports,replicas,version" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestInputsSetErrors(t *testing.T) {
+	in := Inputs{}
+	for _, s := range []string{"app", "=demo"} {
+		if err := in.Set(s); err == nil {
+			t.Errorf("Set(%q): expected an error", s)
+		}
+	}
+
+	// 已经是普通值的参数不能再设置子字段
+	if err := in.Set("app=demo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Set("app.name=demo"); err == nil {
+		t.Error("expected an error when setting a field of a string input")
+	}
+}
+
+func TestInputsMerge(t *testing.T) {
+	in := Inputs{
+		"app":   "demo",
+		"image": map[string]interface{}{"repo": "nginx", "tag": "v1"},
+		"ports": []interface{}{80},
+	}
+	in.Merge(map[string]interface{}{
+		"image":    map[string]interface{}{"tag": "v2"},
+		"ports":    []interface{}{443},
+		"replicas": 2,
+	})
+	want := Inputs{
+		"app":      "demo",
+		"image":    map[string]interface{}{"repo": "nginx", "tag": "v2"},
+		"ports":    []interface{}{443},
+		"replicas": 2,
+	}
+	if !reflect.DeepEqual(in, want) {
+		t.Errorf("merged = %#v, want %#v", in, want)
+	}
+
+	// 对象覆盖普通值
+	in.Merge(map[string]interface{}{"app": map[string]interface{}{"name": "demo"}})
+	if !reflect.DeepEqual(in["app"], map[string]interface{}{"name": "demo"}) {
+		t.Errorf("app = %#v, want an object", in["app"])
+	}
+}
+
+func TestLoadInputsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "inputs.yaml")
+	if err := os.WriteFile(path, []byte("app: demo\nreplicas: 3\nratio: 0.5\nimage:\n  tag: v1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	in, err := LoadInputsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Inputs{"app": "demo", "replicas": int64(3), "ratio": 0.5, "image": map[string]interface{}{"tag": "v1"}}
+	if !reflect.DeepEqual(in, want) {
+		t.Errorf("inputs = %#v, want %#v", in, want)
+	}
+
+	if err := os.WriteFile(path, []byte("- a\n- b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadInputsFile(path); err == nil {
+		t.Error("expected an error for a list at the top level")
+	}
+}
+
+func TestInputsUnmarshalJSON(t *testing.T) {
+	var in Inputs
+	if err := json.Unmarshal([]byte(`{"replicas":3,"ratio":1.5,"ports":[80],"image":{"tag":"v1"}}`), &in); err != nil {
+		t.Fatal(err)
+	}
+	want := Inputs{"replicas": int64(3), "ratio": 1.5, "ports": []interface{}{int64(80)}, "image": map[string]interface{}{"tag": "v1"}}
+	if !reflect.DeepEqual(in, want) {
+		t.Errorf("inputs = %#v, want %#v", in, want)
+	}
+	if err := json.Unmarshal([]byte(`[1]`), &in); err == nil {
+		t.Error("expected an error for a list")
+	}
+}
+
+// 命令行、参数文件解码的参数都要能合并到 int 和 string 字段
+func TestApplyDecodedInputs(t *testing.T) {
+	v := cuecontext.New().CompileString(`
+inputs: {
+	replicas: *1 | int & >0
+	tag:      string
+	debug:    bool
+	ratio:    number
+}
+`)
+	check := func(name string, inputs Inputs) {
+		got, err := ApplyInputs(v, inputs)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			return
+		}
+		if replicas, err := got.LookupPath(cue.ParsePath("inputs.replicas")).Int64(); err != nil || replicas != 3 {
+			t.Errorf("%s: replicas = %d, %v, want 3", name, replicas, err)
+		}
+		// 字段是 string 时取原始文本
+		if tag, _ := got.LookupPath(cue.ParsePath("inputs.tag")).String(); tag != "1.20" {
+			t.Errorf("%s: tag = %q, want 1.20", name, tag)
+		}
+		if debug, _ := got.LookupPath(cue.ParsePath("inputs.debug")).Bool(); !debug {
+			t.Errorf("%s: debug = false, want true", name)
+		}
+	}
+
+	flags := Inputs{}
+	for _, s := range []string{"replicas=3", "tag=1.20", "debug=true", "ratio=0.5"} {
+		if err := flags.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("flags", flags)
+
+	path := filepath.Join(t.TempDir(), "inputs.yaml")
+	if err := os.WriteFile(path, []byte("replicas: 3\ntag: \"1.20\"\ndebug: true\nratio: 0.5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := LoadInputsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("file", file)
+
+	// ResolveInputs 不修改原来的参数
+	resolved := ResolveInputs(v, flags)
+	if resolved["tag"] != "1.20" || resolved["replicas"] != int64(3) {
+		t.Errorf("resolved = %#v", resolved)
+	}
+	if _, ok := flags["tag"].(flagInput); !ok {
+		t.Errorf("flags were modified: %#v", flags)
+	}
+}
+
+func TestApplyInputs(t *testing.T) {
+	v := cuecontext.New().CompileString(`
+inputs: {
+	app:      string
+	replicas: *1 | int & >0
+}
+step1: name: inputs.app
+`)
+
+	got, err := ApplyInputs(v, Inputs{"app": "demo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := got.LookupPath(cue.ParsePath("step1.name")).String(); name != "demo" {
+		t.Errorf("step1.name = %q, want demo", name)
+	}
+	if replicas, _ := got.LookupPath(cue.ParsePath("inputs.replicas")).Int64(); replicas != 1 {
+		t.Errorf("inputs.replicas = %d, want the default 1", replicas)
+	}
+
+	// 缺少必填参数、类型不匹配时返回 InputError
+	for _, inputs := range []Inputs{{}, {"app": "demo", "replicas": 0}, {"app": 1}} {
+		_, err := ApplyInputs(v, inputs)
+		var inputErr *InputError
+		if !errors.As(err, &inputErr) {
+			t.Errorf("ApplyInputs(%v) = %v, want an InputError", inputs, err)
+			continue
+		}
+		if len(inputErr.Details()) == 0 {
+			t.Errorf("ApplyInputs(%v): no details", inputs)
+		}
+	}
+
+	// 没有声明 inputs 的工作流不接受参数
+	plain := cuecontext.New().CompileString(`step1: name: "demo"`)
+	if _, err := ApplyInputs(plain, nil); err != nil {
+		t.Errorf("ApplyInputs without inputs: %v", err)
+	}
+	if _, err := ApplyInputs(plain, Inputs{"app": "demo"}); err == nil {
+		t.Error("expected an error for inputs given to a workflow without inputs")
+	}
+}
@@ -0,0 +1,394 @@
+package k8s_flow
+
+import (
+	"fmt"
+	"strings"
+
+	"cuelang.org/go/cue"
+	cueerrors "cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/token"
+	"cuelang.org/go/tools/flow"
+)
+
+// LintLevel 检查结果的级别，只有 error 会阻止执行
+type LintLevel string
+
+const (
+	LintError   LintLevel = "error"
+	LintWarning LintLevel = "warning"
+)
+
+// LintIssue 静态检查发现的一个问题，Pos 为 cue 源码位置，如 flow.cue:12:3
+type LintIssue struct {
+	Level   LintLevel `json:"level"`
+	Path    string    `json:"path,omitempty"`
+	Pos     string    `json:"pos,omitempty"`
+	Message string    `json:"message"`
+}
+
+func (i LintIssue) String() string {
+	var b strings.Builder
+	if i.Pos != "" {
+		b.WriteString(i.Pos + ": ")
+	}
+	b.WriteString(string(i.Level) + ": ")
+	if i.Path != "" {
+		b.WriteString(i.Path + ": ")
+	}
+	b.WriteString(i.Message)
+	return b.String()
+}
+
+// LintFailed 静态检查发现了 error 级别的问题
+type LintFailed struct {
+	Issues []LintIssue
+}
+
+func (e *LintFailed) Error() string {
+	return "invalid workflow:\n" + strings.Join(e.Details(), "\n")
+}
+
+// Details 每个问题一行，格式同 LintIssue.String
+func (e *LintFailed) Details() []string {
+	var details []string
+	for _, issue := range e.Issues {
+		details = append(details, issue.String())
+	}
+	return details
+}
+
+// CheckLint 执行 Lint，有 error 级别的问题时返回 *LintFailed
+func CheckLint(cfg *flow.Config, v cue.Value, taskFunc flow.TaskFunc) error {
+	return LintErr(Lint(cfg, v, taskFunc))
+}
+
+// LintErr issues 中有 error 级别的问题时返回 *LintFailed
+func LintErr(issues []LintIssue) error {
+	var errs []LintIssue
+	for _, issue := range issues {
+		if issue.Level == LintError {
+			errs = append(errs, issue)
+		}
+	}
+	if len(errs) > 0 {
+		return &LintFailed{Issues: errs}
+	}
+	return nil
+}
+
+// Lint 不执行工作流，静态检查:
+//   - cue 编译错误、cfg.Root 不存在、节点类型未知或输入不满足 schema
+//   - 节点之间的循环依赖
+//   - 节点中既不是具体值、也不引用上游节点结果的字段，如缺少必填字段
+//   - 引用了不存在的节点或字段
+//   - 非节点字段引用节点的结果或不存在的字段，flow 初始化时会因此报错，引用节点结果的字段应该放到 outputs 或节点中
+//   - taskFunc 不为空时节点的值就是 k8s 对象，检查 apiVersion、kind、metadata.name
+//   - kustomize 节点的 kustomization 能否渲染
+//
+// taskFunc 的含义和 BuildPlan 相同
+func Lint(cfg *flow.Config, v cue.Value, taskFunc flow.TaskFunc) []LintIssue {
+	l := &linter{root: v, tasks: map[string]*flow.Task{}, seen: map[string]bool{}}
+	if err := v.Err(); err != nil {
+		l.addErr(err)
+		return l.issues
+	}
+
+	scan := v
+	if cfg != nil && len(cfg.Root.Selectors()) > 0 {
+		if scan = v.LookupPath(cfg.Root); !scan.Exists() {
+			l.add(LintError, cfg.Root.String(), v.Pos(), "root path %s does not exist", cfg.Root)
+			return l.issues
+		}
+	}
+	// 不要求具体值，只报告冲突等永久错误
+	if err := v.Validate(); err != nil {
+		l.addErr(err)
+	}
+
+	objects := taskFunc != nil
+	if taskFunc == nil {
+		taskFunc = declaredTasks
+	}
+	c := flow.New(cfg, v, func(v cue.Value) (flow.Runner, error) {
+		r, err := taskFunc(v)
+		if err != nil {
+			l.addErr(err)
+		}
+		return r, err
+	})
+	for _, t := range c.Tasks() {
+		l.tasks[t.Path().String()] = t
+	}
+	if len(l.tasks) == 0 && len(l.issues) == 0 {
+		l.add(LintWarning, "", scan.Pos(), "no tasks found, the workflow does nothing")
+	}
+
+	l.checkCycles(c.Tasks())
+	for _, t := range c.Tasks() {
+		l.checkTask(t, objects)
+	}
+	if outputs := v.LookupPath(cue.ParsePath(OutputsPath)); outputs.Exists() {
+		l.checkRefs(outputs)
+	}
+	l.checkFields(scan)
+	return l.issues
+}
+
+type linter struct {
+	root   cue.Value
+	tasks  map[string]*flow.Task
+	issues []LintIssue
+	seen   map[string]bool
+}
+
+func (l *linter) add(level LintLevel, path string, pos token.Pos, format string, args ...interface{}) {
+	issue := LintIssue{Level: level, Path: path, Message: fmt.Sprintf(format, args...)}
+	if pos.IsValid() {
+		issue.Pos = pos.String()
+	}
+	// flow 会重复扫描同一个值
+	key := issue.String()
+	if l.seen[key] {
+		return
+	}
+	l.seen[key] = true
+	l.issues = append(l.issues, issue)
+}
+
+func (l *linter) addErr(err error) {
+	for _, e := range cueerrors.Errors(err) {
+		var pos token.Pos
+		if positions := cueerrors.Positions(e); len(positions) > 0 {
+			pos = positions[0]
+		}
+		// Wrapf 包装的错误 Msg 只有外层的信息，使用完整的错误，其中已经包含路径
+		path, msg := strings.Join(e.Path(), "."), e.Error()
+		if strings.Contains(msg, path) {
+			path = ""
+		}
+		l.add(LintError, path, pos, "%s", msg)
+	}
+}
+
+// checkCycles 深度优先查找循环依赖，每个环只报告一次
+func (l *linter) checkCycles(tasks []*flow.Task) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[*flow.Task]int{}
+	var stack []*flow.Task
+	var visit func(t *flow.Task)
+	visit = func(t *flow.Task) {
+		state[t] = visiting
+		stack = append(stack, t)
+		for _, dep := range t.Dependencies() {
+			switch state[dep] {
+			case visiting:
+				var cycle []string
+				for i := len(stack) - 1; i >= 0; i-- {
+					cycle = append([]string{stack[i].Path().String()}, cycle...)
+					if stack[i] == dep {
+						break
+					}
+				}
+				cycle = append(cycle, dep.Path().String())
+				l.add(LintError, dep.Path().String(), dep.Value().Pos(), "cyclic task dependency: %s", strings.Join(cycle, " -> "))
+			case 0:
+				visit(dep)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[t] = done
+	}
+	for _, t := range tasks {
+		if state[t] == 0 {
+			visit(t)
+		}
+	}
+}
+
+// checkTask 检查节点合并 schema 后的每个字段，compensate 和 foreach 的 task 是运行时才求值的子节点，不检查
+func (l *linter) checkTask(t *flow.Task, objects bool) {
+	v := t.Value()
+	in := v
+	kind, err := newTask(v)
+	if err == nil && kind != nil {
+		in = kind.compileSchema(v).Unify(v)
+	} else if objects && kind == nil {
+		l.checkObject(t.Path().String(), v)
+	}
+
+	skip := map[string]bool{"compensate": true}
+	if kind == ForEachTask {
+		skip["task"] = true
+	}
+	l.walk(in, func(path cue.Path, field cue.Value) bool {
+		sels := path.Selectors()
+		if len(sels) > 0 && skip[sels[0].String()] {
+			return false
+		}
+		if isConcrete(field) || field.IncompleteKind() == cue.StructKind || field.IncompleteKind() == cue.ListKind {
+			return true
+		}
+		name := joinPath(t.Path().String(), path.String())
+		orig := v.LookupPath(path)
+		if !orig.Exists() {
+			l.add(LintError, name, v.Pos(), "missing required field %s", path)
+			return false
+		}
+		l.checkLeaf(name, orig)
+		return false
+	})
+	if kind == KustomizeTask {
+		l.checkKustomization(t.Path().String(), v)
+	}
+}
+
+// checkKustomization dir 是具体值时渲染一次，检查 kustomization 是否有效
+func (l *linter) checkKustomization(path string, v cue.Value) {
+	dir := v.LookupPath(cue.ParsePath("dir"))
+	if !isConcrete(dir) {
+		return
+	}
+	if _, err := renderKustomization(v); err != nil {
+		l.add(LintError, joinPath(path, "dir"), dir.Pos(), "%v", err)
+	}
+}
+
+// checkObject taskFunc 识别出的节点就是 k8s 对象
+func (l *linter) checkObject(path string, v cue.Value) {
+	for _, field := range []string{"apiVersion", "kind", "metadata.name"} {
+		if !v.LookupPath(cue.ParsePath(field)).Exists() {
+			l.add(LintError, path, v.Pos(), "kubernetes object is missing %s", field)
+		}
+	}
+}
+
+// checkLeaf 不是具体值的字段必须引用上游节点，引用的路径必须存在
+func (l *linter) checkLeaf(name string, v cue.Value) {
+	runtime, broken := l.resolveRefs(v)
+	if !runtime && !broken {
+		l.add(LintError, name, v.Pos(), "field is not concrete (%v) and does not refer to any task result", v.IncompleteKind())
+	}
+}
+
+// checkRefs 只检查 v 下所有字段引用的路径是否存在，用于 outputs
+func (l *linter) checkRefs(v cue.Value) {
+	l.walk(v, func(_ cue.Path, field cue.Value) bool {
+		if isConcrete(field) {
+			return true
+		}
+		l.resolveRefs(field)
+		return true
+	})
+}
+
+// checkFields 非节点字段不能引用节点的结果
+func (l *linter) checkFields(scan cue.Value) {
+	l.walk(scan, func(_ cue.Path, field cue.Value) bool {
+		path := field.Path().String()
+		if l.tasks[path] != nil || path == OutputsPath {
+			return false
+		}
+		if isConcrete(field) {
+			return true
+		}
+		for _, ref := range references(field) {
+			if task := l.taskOf(ref); task != "" {
+				l.add(LintError, path, field.Pos(), "refers to the result of task %s, but only tasks and %s can refer to task results", task, OutputsPath)
+			}
+		}
+		l.resolveRefs(field)
+		return true
+	})
+}
+
+// resolveRefs 检查 v 引用的路径，runtime 表示引用了节点的结果，broken 表示引用的路径不存在
+func (l *linter) resolveRefs(v cue.Value) (runtime, broken bool) {
+	for _, ref := range references(v) {
+		if l.taskOf(ref) != "" {
+			runtime = true
+			continue
+		}
+		root, path := ref.ReferencePath()
+		sels := path.Selectors()
+		for i := 1; i <= len(sels); i++ {
+			prefix := cue.MakePath(sels[:i]...)
+			if !root.LookupPath(prefix).Exists() {
+				l.add(LintError, v.Path().String(), ref.Pos(), "reference to undefined task or field %s", prefix)
+				broken = true
+				break
+			}
+		}
+	}
+	return runtime, broken
+}
+
+// taskOf 引用路径所在的节点，路径不在任何节点下时返回空
+func (l *linter) taskOf(ref cue.Value) string {
+	_, path := ref.ReferencePath()
+	sels := path.Selectors()
+	for i := 1; i <= len(sels); i++ {
+		if prefix := cue.MakePath(sels[:i]...).String(); l.tasks[prefix] != nil {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// walk 深度优先遍历 v 下的普通字段，fn 返回 false 时不再遍历子字段，path 相对于 v
+func (l *linter) walk(v cue.Value, fn func(path cue.Path, field cue.Value) bool) {
+	var visit func(sels []cue.Selector, v cue.Value)
+	visit = func(sels []cue.Selector, v cue.Value) {
+		switch v.IncompleteKind() {
+		case cue.StructKind:
+			iter, err := v.Fields()
+			if err != nil {
+				return
+			}
+			for iter.Next() {
+				child := append(append([]cue.Selector{}, sels...), iter.Selector())
+				if fn(cue.MakePath(child...), iter.Value()) {
+					visit(child, iter.Value())
+				}
+			}
+		case cue.ListKind:
+			iter, err := v.List()
+			if err != nil {
+				return
+			}
+			for iter.Next() {
+				child := append(append([]cue.Selector{}, sels...), iter.Selector())
+				if fn(cue.MakePath(child...), iter.Value()) {
+					visit(child, iter.Value())
+				}
+			}
+		}
+	}
+	visit(nil, v)
+}
+
+// references v 的表达式中引用其他字段的部分，如 "x" + step1.live.spec.clusterIP 中的 step1.live.spec.clusterIP
+func references(v cue.Value) []cue.Value {
+	if _, path := v.ReferencePath(); len(path.Selectors()) > 0 {
+		return []cue.Value{v}
+	}
+	op, args := v.Expr()
+	if op == cue.NoOp && len(args) == 1 {
+		// 不是表达式
+		return nil
+	}
+	var refs []cue.Value
+	for _, arg := range args {
+		refs = append(refs, references(arg)...)
+	}
+	return refs
+}
+
+func isConcrete(v cue.Value) bool {
+	if d, ok := v.Default(); ok {
+		v = d
+	}
+	return v.Err() == nil && v.IsConcrete()
+}
@@ -0,0 +1,169 @@
+package k8s_flow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/encoding/yaml"
+)
+
+// ManifestAttr 引用 YAML 清单的字段属性，路径相对于属性所在的 cue 文件，包含 .. 等符号时需要加引号:
+//
+//	step1: _ @manifest("../yamls/deploy.yaml")
+//	step2: {metadata: namespace: "demo"} @manifest("../yamls/service.yaml")
+//	app:   _ @manifest("../manifests", apply)
+//
+// 文件只有一个文档时字段的值就是这个对象，多个文档（---分隔）、顶层是列表或目录时是对象的列表，
+// 目录下按文件名排序读取 .yaml、.yml、.json 文件，不递归。
+// 加上 apply 时每个对象展开为一个 apply 节点，以 kind-name 小写作为字段名，如 app["deployment-flowdeploy"]。
+// 清单和字段原有的值合并，其他节点可以像 cue 中定义的对象一样引用
+const ManifestAttr = "manifest"
+
+// LoadManifests 在 flow.New 之前把 @manifest 引用的 YAML 清单解码后合并到对应的字段，
+// 解码保留了 YAML 中的位置，校验错误会指向 YAML 文件的行号
+func LoadManifests(v cue.Value) (cue.Value, error) {
+	type ref struct {
+		path  cue.Path
+		value cue.Value
+		attr  cue.Attribute
+	}
+	var refs []ref
+	var walk func(v cue.Value)
+	walk = func(v cue.Value) {
+		if v.IncompleteKind() != cue.StructKind {
+			return
+		}
+		iter, err := v.Fields()
+		if err != nil {
+			return
+		}
+		for iter.Next() {
+			field := iter.Value()
+			if attr := field.Attribute(ManifestAttr); attr.Err() == nil {
+				refs = append(refs, ref{path: field.Path(), value: field, attr: attr})
+				continue
+			}
+			walk(field)
+		}
+	}
+	walk(v)
+
+	for _, r := range refs {
+		file, err := r.attr.String(0)
+		if err != nil {
+			return v, errors.Wrapf(err, r.value.Pos(), "invalid @%s attribute of %v", ManifestAttr, r.path)
+		}
+		asTasks, err := r.attr.Flag(1, "apply")
+		if err != nil {
+			return v, errors.Wrapf(err, r.value.Pos(), "invalid @%s attribute of %v", ManifestAttr, r.path)
+		}
+		if filename := r.value.Pos().Filename(); !filepath.IsAbs(file) && filename != "" {
+			file = filepath.Join(filepath.Dir(filename), file)
+		}
+
+		docs, err := decodeManifests(v.Context(), file)
+		if err != nil {
+			return v, errors.Wrapf(err, r.value.Pos(), "load manifests of %v", r.path)
+		}
+		var manifest cue.Value
+		switch {
+		case asTasks:
+			manifest = applyTasks(v.Context(), docs)
+		case len(docs) == 1:
+			manifest = docs[0]
+		default:
+			manifest = v.Context().NewList(docs...)
+		}
+		v = v.FillPath(r.path, manifest)
+	}
+	return v, v.Err()
+}
+
+// decodeManifests 读取文件或目录下的所有文档，跳过空文档
+func decodeManifests(ctx *cue.Context, path string) ([]cue.Value, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, e := range entries {
+			switch strings.ToLower(filepath.Ext(e.Name())) {
+			case ".yaml", ".yml", ".json":
+				if !e.IsDir() {
+					files = append(files, filepath.Join(path, e.Name()))
+				}
+			}
+		}
+		sort.Strings(files)
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no .yaml, .yml or .json files in %s", path)
+		}
+	}
+
+	var docs []cue.Value
+	for _, file := range files {
+		f, err := yaml.Extract(file, nil)
+		if err != nil {
+			return nil, err
+		}
+		v := ctx.BuildFile(f)
+		if v.Err() != nil {
+			return nil, v.Err()
+		}
+		// 多个文档解码为列表，顶层是列表的单个文档同样展开
+		if v.Kind() != cue.ListKind {
+			if v.Kind() != cue.NullKind {
+				docs = append(docs, v)
+			}
+			continue
+		}
+		iter, _ := v.List()
+		for iter.Next() {
+			if iter.Value().Kind() != cue.NullKind {
+				docs = append(docs, iter.Value())
+			}
+		}
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no documents in %s", path)
+	}
+	return docs, nil
+}
+
+// applyTasks 每个对象展开为一个 apply 节点
+func applyTasks(ctx *cue.Context, docs []cue.Value) cue.Value {
+	tasks := ctx.CompileString("{}")
+	used := map[string]int{}
+	for i, doc := range docs {
+		kind, _ := doc.LookupPath(cue.ParsePath("kind")).String()
+		name, _ := doc.LookupPath(cue.ParsePath("metadata.name")).String()
+		key := objectKey(used, kind, name, i)
+		tasks = tasks.
+			FillPath(cue.MakePath(cue.Str(key), cue.Str(TaskMarker)), ApplyTask.Name).
+			FillPath(cue.MakePath(cue.Str(key), cue.Str("object")), doc)
+	}
+	return tasks
+}
+
+// objectKey 对象展开后的字段名，kind-name 小写，重复时加上序号，缺少 kind 或 name 时为 doc-i
+func objectKey(used map[string]int, kind, name string, i int) string {
+	key := strings.ToLower(kind + "-" + name)
+	if kind == "" || name == "" {
+		key = fmt.Sprintf("doc-%d", i)
+	}
+	if used[key]++; used[key] > 1 {
+		key = fmt.Sprintf("%s-%d", key, used[key])
+	}
+	return key
+}
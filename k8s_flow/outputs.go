@@ -0,0 +1,206 @@
+package k8s_flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/tools/flow"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// OutputsPath 工作流声明输出的路径，引用节点回填的结果，执行成功后求值：
+//
+//	outputs: {
+//		clusterIP: step2.live.spec.clusterIP
+//		revision:  step1.live.metadata.annotations["deployment.kubernetes.io/revision"]
+//	}
+const OutputsPath = "outputs"
+
+// outputsConfigMapKey ConfigMap 中保存完整输出的键，其他键是顶层字段
+const outputsConfigMapKey = "outputs.json"
+
+// EvalOutputs 对执行结束后的值求 outputs，没有声明时返回 nil，
+// 引用的节点没有结果（如被跳过）导致不是具体值时返回 cue 的错误
+func EvalOutputs(v cue.Value) (json.RawMessage, error) {
+	outputs := v.LookupPath(cue.ParsePath(OutputsPath))
+	if !outputs.Exists() {
+		return nil, nil
+	}
+	if err := outputs.Validate(cue.Concrete(true)); err != nil {
+		return nil, err
+	}
+	return outputs.MarshalJSON()
+}
+
+// outputsRunner outputs 引用的节点结果在回填之前是未定义的字段，flow 扫描节点时会当作错误，
+// 所以 Run 把 outputs 当作一个什么都不做的隐式节点，flow 会在它引用的节点都完成后才运行它。
+// 隐式节点不记录状态，也不出现在事件和依赖图中
+var outputsRunner = flow.RunnerFunc(func(t *flow.Task) error {
+	return nil
+})
+
+func isOutputsTask(t *flow.Task) bool {
+	return t.Path().String() == OutputsPath
+}
+
+// OutputExporter 执行成功后导出 outputs，如写入文件或 ConfigMap，供下游的 CI 步骤读取
+type OutputExporter interface {
+	Export(ctx context.Context, runID string, outputs json.RawMessage) error
+}
+
+// WithOutputExporters 执行成功后依次导出 outputs，导出失败时执行失败
+func WithOutputExporters(exporters ...OutputExporter) RunOption {
+	return func(r *Run) {
+		r.exporters = append(r.exporters, exporters...)
+	}
+}
+
+// FileExporter 把 outputs 写入文件，扩展名是 .yaml、.yml 时写 YAML，否则写 JSON
+type FileExporter struct {
+	Path string
+}
+
+func (e *FileExporter) Export(ctx context.Context, runID string, outputs json.RawMessage) error {
+	data, err := FormatOutputs(outputs, filepath.Ext(e.Path))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(e.Path, data, 0o644)
+}
+
+// FormatOutputs 按格式编码 outputs，format 为 yaml、.yaml、.yml 时输出 YAML，否则输出缩进的 JSON
+func FormatOutputs(outputs json.RawMessage, format string) ([]byte, error) {
+	if len(outputs) == 0 {
+		outputs = json.RawMessage("{}")
+	}
+	switch strings.TrimPrefix(format, ".") {
+	case "yaml", "yml":
+		return yaml.JSONToYAML(outputs)
+	}
+	var v interface{}
+	if err := json.Unmarshal(outputs, &v); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// ConfigMapExporter 把 outputs 写入 ConfigMap，outputs.json 保存完整输出，
+// 顶层字段另外各占一个键（字符串原样保存，其他值保存 JSON），方便通过 envFrom 引用
+type ConfigMapExporter struct {
+	Client    kubernetes.Interface
+	Namespace string
+	// Name 缺省为 k8sflow-outputs-<runID>
+	Name string
+}
+
+func (e *ConfigMapExporter) name(runID string) string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return "k8sflow-outputs-" + runID
+}
+
+func (e *ConfigMapExporter) Export(ctx context.Context, runID string, outputs json.RawMessage) error {
+	data, err := outputsConfigMapData(outputs)
+	if err != nil {
+		return err
+	}
+
+	configMaps := e.Client.CoreV1().ConfigMaps(e.Namespace)
+	cm, err := configMaps.Get(ctx, e.name(runID), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      e.name(runID),
+				Namespace: e.Namespace,
+				Labels:    map[string]string{"app.kubernetes.io/managed-by": "k8sflow"},
+			},
+			Data: data,
+		}
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// 整体替换，去掉上次执行留下的字段
+	cm.Data = data
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+func outputsConfigMapData(outputs json.RawMessage) (map[string]string, error) {
+	data := map[string]string{outputsConfigMapKey: string(outputs)}
+	fields := map[string]json.RawMessage{}
+	// outputs 不是对象时只保存完整输出
+	if err := json.Unmarshal(outputs, &fields); err != nil {
+		return data, nil
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == outputsConfigMapKey {
+			return nil, fmt.Errorf("output %s conflicts with the key of the full outputs", k)
+		}
+		// 不能作为 ConfigMap 键的字段只保存在完整输出中
+		if len(validation.IsConfigMapKey(k)) > 0 {
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(fields[k], &s); err == nil {
+			data[k] = s
+			continue
+		}
+		data[k] = string(fields[k])
+	}
+	return data, nil
+}
+
+// Outputs 执行成功后求得的 outputs，执行未结束、失败或没有声明时为 nil
+func (r *Run) Outputs() json.RawMessage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.outputs
+}
+
+// collectOutputs 执行成功后求 outputs 并导出
+func (r *Run) collectOutputs(ctx context.Context) error {
+	outputs, err := EvalOutputs(r.controller.Value())
+	if err != nil {
+		return fmt.Errorf("evaluate %s: %v", OutputsPath, err)
+	}
+	r.mu.Lock()
+	r.outputs = outputs
+	r.mu.Unlock()
+	r.save(ctx)
+
+	if outputs == nil {
+		return nil
+	}
+	for _, e := range r.exporters {
+		if err := e.Export(ctx, r.id, outputs); err != nil {
+			return fmt.Errorf("export %s: %v", OutputsPath, err)
+		}
+	}
+	return nil
+}
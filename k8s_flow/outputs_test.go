@@ -0,0 +1,121 @@
+package k8s_flow
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOutputsConfigMapData(t *testing.T) {
+	outputs := json.RawMessage(`{"clusterIP":"10.0.0.1","replicas":3,"ports":[80],"ready":true,"bad key!":"x"}`)
+	data, err := outputsConfigMapData(outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		outputsConfigMapKey: string(outputs),
+		"clusterIP":         "10.0.0.1",
+		"replicas":          "3",
+		"ports":             "[80]",
+		"ready":             "true",
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("data = %#v, want %#v", data, want)
+	}
+}
+
+func TestOutputsConfigMapDataNotObject(t *testing.T) {
+	data, err := outputsConfigMapData(json.RawMessage(`"10.0.0.1"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{outputsConfigMapKey: `"10.0.0.1"`}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("data = %#v, want %#v", data, want)
+	}
+}
+
+func TestOutputsConfigMapDataConflict(t *testing.T) {
+	if _, err := outputsConfigMapData(json.RawMessage(`{"outputs.json":"x"}`)); err == nil {
+		t.Errorf("expected an error for an output named %s", outputsConfigMapKey)
+	}
+}
+
+func TestEvalOutputs(t *testing.T) {
+	ctx := cuecontext.New()
+
+	outputs, err := EvalOutputs(ctx.CompileString(`
+step1: live: spec: clusterIP: "10.0.0.1"
+outputs: clusterIP: step1.live.spec.clusterIP
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(outputs) != `{"clusterIP":"10.0.0.1"}` {
+		t.Errorf("outputs = %s", outputs)
+	}
+
+	// 没有声明 outputs
+	if outputs, err = EvalOutputs(ctx.CompileString(`step1: {}`)); err != nil || outputs != nil {
+		t.Errorf("EvalOutputs = %s, %v, want nil", outputs, err)
+	}
+
+	// 引用的字段没有回填
+	if _, err = EvalOutputs(ctx.CompileString(`outputs: clusterIP: string`)); err == nil {
+		t.Error("expected an error for outputs that are not concrete")
+	}
+}
+
+func TestFormatOutputs(t *testing.T) {
+	outputs := json.RawMessage(`{"b":1,"a":"x"}`)
+	data, err := FormatOutputs(outputs, ".yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "a: x\nb: 1\n" {
+		t.Errorf("yaml = %q", data)
+	}
+
+	data, err = FormatOutputs(outputs, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "{\n  \"a\": \"x\",\n  \"b\": 1\n}\n" {
+		t.Errorf("json = %q", data)
+	}
+
+	if data, err = FormatOutputs(nil, ""); err != nil || string(data) != "{}\n" {
+		t.Errorf("empty outputs = %q, %v", data, err)
+	}
+}
+
+func TestConfigMapExporter(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	e := &ConfigMapExporter{Client: client, Namespace: "default"}
+
+	if err := e.Export(ctx, "run1", json.RawMessage(`{"a":"1","b":"2"}`)); err != nil {
+		t.Fatal(err)
+	}
+	// 再次导出时整体替换
+	if err := e.Export(ctx, "run1", json.RawMessage(`{"a":"3"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	cm, err := client.CoreV1().ConfigMaps("default").Get(ctx, "k8sflow-outputs-run1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{outputsConfigMapKey: `{"a":"3"}`, "a": "3"}
+	if !reflect.DeepEqual(cm.Data, want) {
+		t.Errorf("data = %#v, want %#v", cm.Data, want)
+	}
+	if cm.Labels["app.kubernetes.io/managed-by"] != "k8sflow" {
+		t.Errorf("labels = %v", cm.Labels)
+	}
+}
@@ -0,0 +1,504 @@
+package k8s_flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/tools/flow"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/penk110/k8s_operator/k8s_client"
+)
+
+// KnownAfterApply 运行时才能确定的字段在 plan 中的显示
+const KnownAfterApply = "(known after apply)"
+
+// PlanAction 节点在 plan 中的动作
+type PlanAction string
+
+const (
+	PlanCreate PlanAction = "create"
+	PlanUpdate PlanAction = "update"
+	PlanNoop   PlanAction = "no-op"
+	PlanDelete PlanAction = "delete"
+	// PlanApply 对象名称运行时才确定，无法判断是创建还是修改
+	PlanApply PlanAction = "apply"
+	// PlanRun 不修改集群对象或无法预先计算影响的节点，如 http、exec-job
+	PlanRun  PlanAction = "run"
+	PlanSkip PlanAction = "skip"
+)
+
+// FieldDiff 一个字段的变化，Op 为 + 新增、- 删除、~ 修改
+type FieldDiff struct {
+	Op     string      `json:"op"`
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+	// KnownAfterApply 字段依赖上游节点的输出，运行时才确定
+	KnownAfterApply bool `json:"knownAfterApply,omitempty"`
+}
+
+// PlanStep 一个节点的执行计划
+type PlanStep struct {
+	Path   string     `json:"path"`
+	Kind   string     `json:"kind"`
+	Action PlanAction `json:"action"`
+	// Resource 节点操作的对象，如 apps/v1 Deployment default/flowdeploy
+	Resource  string      `json:"resource,omitempty"`
+	DependsOn []string    `json:"dependsOn,omitempty"`
+	Diffs     []FieldDiff `json:"diffs,omitempty"`
+	Warnings  []string    `json:"warnings,omitempty"`
+	Note      string      `json:"note,omitempty"`
+	Error     string      `json:"error,omitempty"`
+
+	// target BuildTeardown 中要删除的对象
+	target *unstructured.Unstructured
+}
+
+// Plan 工作流的执行计划，Steps 按依赖关系排序
+type Plan struct {
+	Steps []PlanStep `json:"steps"`
+}
+
+// BuildPlan 不执行工作流，按 tools/flow 识别的依赖顺序计算每个节点的影响：
+// apply 节点通过服务端 dry-run 和线上对象对比，delete 节点检查对象是否存在，其他节点只列出。
+// taskFunc 为空时按 $task/@task 识别节点，否则使用 taskFunc 识别，
+// 识别出的节点没有声明类型时把节点的值当作 apply 的对象，如 deployment_1 的 handler.Handler
+func BuildPlan(ctx context.Context, cfg *flow.Config, v cue.Value, taskFunc flow.TaskFunc) (*Plan, error) {
+	if taskFunc == nil {
+		taskFunc = declaredTasks
+	}
+	c := flow.New(cfg, v, taskFunc)
+
+	plan := &Plan{}
+	for _, t := range sortTasks(c.Tasks()) {
+		step, err := planTask(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		plan.Steps = append(plan.Steps, *step)
+	}
+	return plan, nil
+}
+
+// declaredTasks 按 $task/@task 识别节点，只用于计算计划，不会执行
+func declaredTasks(v cue.Value) (flow.Runner, error) {
+	kind, err := newTask(v)
+	if err != nil || kind == nil {
+		return nil, err
+	}
+	return flow.RunnerFunc(func(t *flow.Task) error { return nil }), nil
+}
+
+// sortTasks 按依赖关系拓扑排序，没有依赖关系的节点保持定义顺序
+func sortTasks(tasks []*flow.Task) []*flow.Task {
+	indegree := map[*flow.Task]int{}
+	dependents := map[*flow.Task][]*flow.Task{}
+	for _, t := range tasks {
+		indegree[t] = len(t.Dependencies())
+		for _, dep := range t.Dependencies() {
+			dependents[dep] = append(dependents[dep], t)
+		}
+	}
+
+	var ready, sorted []*flow.Task
+	for _, t := range tasks {
+		if indegree[t] == 0 {
+			ready = append(ready, t)
+		}
+	}
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return ready[i].Index() < ready[j].Index() })
+		t := ready[0]
+		ready = ready[1:]
+		sorted = append(sorted, t)
+		for _, next := range dependents[t] {
+			if indegree[next]--; indegree[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+	return sorted
+}
+
+func planTask(ctx context.Context, t *flow.Task) (*PlanStep, error) {
+	step := &PlanStep{Path: t.Path().String()}
+	for _, dep := range t.Dependencies() {
+		step.DependsOn = append(step.DependsOn, dep.Path().String())
+	}
+
+	kind, err := newTask(t.Value())
+	if err != nil {
+		return nil, err
+	}
+	if kind == nil {
+		// 没有声明类型，整个值就是要 apply 的对象
+		step.Kind = ApplyTask.Name
+		planApply(ctx, step, t.Value())
+		return step, nil
+	}
+
+	step.Kind = kind.Name
+	in, err := kind.input(t.Value())
+	if err != nil {
+		return nil, err
+	}
+	if when := in.LookupPath(cue.ParsePath("when")); when.Exists() {
+		ok, err := when.Bool()
+		switch {
+		case err != nil:
+			step.Note = "when " + KnownAfterApply
+		case !ok:
+			step.Action = PlanSkip
+			step.Note = "when is false"
+			return step, nil
+		}
+	}
+
+	if kind.Plan == nil {
+		step.Action = PlanRun
+		return step, nil
+	}
+	kind.Plan(ctx, step, in)
+	return step, nil
+}
+
+// planApply 计算 apply 对象的变化，依赖上游输出的字段标记为 known after apply，
+// 其余字段通过服务端 dry-run 得到 apply 之后的对象
+func planApply(ctx context.Context, step *PlanStep, v cue.Value) {
+	var unknown []string
+	local, _ := concreteValue(v, "", &unknown).(map[string]interface{})
+	obj := &unstructured.Unstructured{Object: local}
+	step.Resource = planResource(obj)
+
+	unknownDiffs := func(op string) []FieldDiff {
+		diffs := make([]FieldDiff, 0, len(unknown))
+		for _, path := range unknown {
+			diffs = append(diffs, FieldDiff{Op: op, Path: path, KnownAfterApply: true})
+		}
+		return diffs
+	}
+
+	if obj.GetName() == "" || obj.GetKind() == "" {
+		step.Action = PlanApply
+		step.Diffs = append(diffDocuments(nil, local), unknownDiffs("~")...)
+		sortDiffs(step.Diffs)
+		return
+	}
+
+	if warning, deprecated := k8s_client.CheckDeprecatedAPI(obj.GetAPIVersion(), obj.GetKind()); deprecated {
+		step.Warnings = append(step.Warnings, warning.Text)
+	}
+	data, err := json.Marshal(local)
+	if err != nil {
+		step.Action = PlanApply
+		step.Error = err.Error()
+		return
+	}
+	result, err := k8s_client.DryRunApply(data, k8s_client.GetConfig(), k8s_client.CachedRestMapper())
+	if err != nil {
+		// 缺少运行时才确定的必填字段时 dry-run 会失败，仍然列出本地的对象
+		step.Action = PlanApply
+		step.Error = "dry-run: " + err.Error()
+		step.Diffs = append(diffDocuments(nil, local), unknownDiffs("~")...)
+		sortDiffs(step.Diffs)
+		return
+	}
+	for _, w := range result.Warnings {
+		step.Warnings = append(step.Warnings, w.Text)
+	}
+
+	if result.Live == nil {
+		// 创建时只展示声明的字段，不展示服务端补充的缺省值
+		step.Action = PlanCreate
+		step.Resource = planResource(result.Object)
+		step.Diffs = append(diffDocuments(nil, local), unknownDiffs("+")...)
+		sortDiffs(step.Diffs)
+		return
+	}
+
+	step.Resource = planResource(result.Live)
+	for _, d := range diffDocuments(diffable(result.Live), diffable(result.Object)) {
+		if !underAny(d.Path, unknown) {
+			step.Diffs = append(step.Diffs, d)
+		}
+	}
+	step.Diffs = append(step.Diffs, unknownDiffs("~")...)
+	sortDiffs(step.Diffs)
+	step.Action = PlanUpdate
+	if len(step.Diffs) == 0 {
+		step.Action = PlanNoop
+	}
+}
+
+// planDelete delete 节点删除的对象是否存在
+func planDelete(ctx context.Context, step *PlanStep, v cue.Value) {
+	ref, err := resolveRef(v)
+	if err != nil {
+		step.Action = PlanDelete
+		step.Resource = KnownAfterApply
+		return
+	}
+	step.Resource = fmt.Sprintf("%s %s", ref.APIVersion, ref)
+
+	live, err := k8s_client.GetObject(k8s_client.GetConfig(), k8s_client.CachedRestMapper(), ref.gvk(), ref.Namespace, ref.Name)
+	switch {
+	case apierrors.IsNotFound(err):
+		step.Action = PlanNoop
+		step.Note = "object does not exist"
+	case err != nil:
+		step.Action = PlanDelete
+		step.Error = err.Error()
+	default:
+		step.Action = PlanDelete
+		step.Resource = planResource(live.Object)
+	}
+}
+
+func planResource(obj *unstructured.Unstructured) string {
+	name := obj.GetName()
+	if name == "" {
+		name = KnownAfterApply
+	}
+	if obj.GetNamespace() != "" {
+		name = obj.GetNamespace() + "/" + name
+	}
+	return fmt.Sprintf("%s %s %s", obj.GetAPIVersion(), obj.GetKind(), name)
+}
+
+// concreteValue 解码 v 中已经确定的部分，不确定的字段路径记录到 unknown 中并从结果里去掉
+func concreteValue(v cue.Value, path string, unknown *[]string) interface{} {
+	switch v.IncompleteKind() {
+	case cue.StructKind:
+		iter, err := v.Fields()
+		if err != nil {
+			*unknown = append(*unknown, path)
+			return nil
+		}
+		out := map[string]interface{}{}
+		fields := 0
+		for iter.Next() {
+			fields++
+			field := joinPath(path, iter.Selector().Unquoted())
+			if x := concreteValue(iter.Value(), field, unknown); x != nil {
+				out[iter.Selector().Unquoted()] = x
+			}
+		}
+		if fields > 0 && len(out) == 0 && path != "" {
+			// 所有字段都不确定
+			return nil
+		}
+		return out
+	case cue.ListKind:
+		iter, err := v.List()
+		if err != nil {
+			*unknown = append(*unknown, path)
+			return nil
+		}
+		out := []interface{}{}
+		known := len(*unknown)
+		for i := 0; iter.Next(); i++ {
+			n := len(*unknown)
+			x := concreteValue(iter.Value(), fmt.Sprintf("%s[%d]", path, i), unknown)
+			if x == nil && len(*unknown) > n {
+				// 列表元素不能省略，省略后下标会错位，有不确定的元素时整个列表不确定
+				*unknown = append((*unknown)[:known], path)
+				return nil
+			}
+			out = append(out, x)
+		}
+		return out
+	}
+
+	// 执行时会取缺省值，如 *"demo" | string
+	if d, ok := v.Default(); ok {
+		v = d
+	}
+	if !v.IsConcrete() {
+		*unknown = append(*unknown, path)
+		return nil
+	}
+	var x interface{}
+	if err := v.Decode(&x); err != nil {
+		*unknown = append(*unknown, path)
+		return nil
+	}
+	return x
+}
+
+// diffable 去掉服务端维护的字段，只比较声明的内容
+func diffable(obj *unstructured.Unstructured) map[string]interface{} {
+	out := restorable(obj)
+	unstructured.RemoveNestedField(out, "metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration")
+	if annotations, ok, _ := unstructured.NestedMap(out, "metadata", "annotations"); ok && len(annotations) == 0 {
+		unstructured.RemoveNestedField(out, "metadata", "annotations")
+	}
+	return out
+}
+
+// diffDocuments 按叶子字段比较两个对象
+func diffDocuments(before, after map[string]interface{}) []FieldDiff {
+	b, a := map[string]interface{}{}, map[string]interface{}{}
+	flatten("", before, b)
+	flatten("", after, a)
+
+	var diffs []FieldDiff
+	for path, av := range a {
+		bv, ok := b[path]
+		switch {
+		case !ok:
+			diffs = append(diffs, FieldDiff{Op: "+", Path: path, After: av})
+		case !reflect.DeepEqual(bv, av):
+			diffs = append(diffs, FieldDiff{Op: "~", Path: path, Before: bv, After: av})
+		}
+	}
+	for path, bv := range b {
+		if _, ok := a[path]; !ok {
+			diffs = append(diffs, FieldDiff{Op: "-", Path: path, Before: bv})
+		}
+	}
+	sortDiffs(diffs)
+	return diffs
+}
+
+func flatten(path string, v interface{}, out map[string]interface{}) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if len(x) == 0 && path != "" {
+			out[path] = x
+		}
+		for k, item := range x {
+			flatten(joinPath(path, k), item, out)
+		}
+	case []interface{}:
+		if len(x) == 0 {
+			out[path] = x
+		}
+		for i, item := range x {
+			flatten(fmt.Sprintf("%s[%d]", path, i), item, out)
+		}
+	case nil:
+		// null 值没有可比较的叶子字段
+	default:
+		out[path] = normalizeNumber(x)
+	}
+}
+
+// normalizeNumber cue 解码的整数和 json 解码的数字类型不同，统一后再比较
+func normalizeNumber(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int:
+		return int64(x)
+	case float64:
+		if x == float64(int64(x)) {
+			return int64(x)
+		}
+	}
+	return v
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// underAny path 是否等于或位于 prefixes 中某个路径之下
+func underAny(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+".") || strings.HasPrefix(path, prefix+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+func sortDiffs(diffs []FieldDiff) {
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+}
+
+// Summary 统计新增、修改、删除、其他执行的节点数
+func (p *Plan) Summary() (add, change, destroy, run int) {
+	for _, step := range p.Steps {
+		switch step.Action {
+		case PlanCreate:
+			add++
+		case PlanUpdate, PlanApply:
+			change++
+		case PlanDelete:
+			destroy++
+		case PlanRun:
+			run++
+		}
+	}
+	return
+}
+
+var planHeaders = map[PlanAction]string{
+	PlanCreate: "will be created",
+	PlanUpdate: "will be updated in-place",
+	PlanNoop:   "has no changes",
+	PlanDelete: "will be destroyed",
+	PlanApply:  "will be created or updated",
+	PlanRun:    "will run",
+	PlanSkip:   "will be skipped",
+}
+
+// Render 以类似 terraform plan 的格式输出
+func (p *Plan) Render(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("Workflow plan:\n")
+	for _, step := range p.Steps {
+		b.WriteString("\n")
+		fmt.Fprintf(&b, "  # %s (%s)", step.Path, step.Kind)
+		if step.Resource != "" {
+			fmt.Fprintf(&b, ": %s", step.Resource)
+		}
+		fmt.Fprintf(&b, " %s\n", planHeaders[step.Action])
+		if step.Note != "" {
+			fmt.Fprintf(&b, "    (%s)\n", step.Note)
+		}
+		if len(step.DependsOn) > 0 {
+			fmt.Fprintf(&b, "    depends on: %s\n", strings.Join(step.DependsOn, ", "))
+		}
+		for _, d := range step.Diffs {
+			switch {
+			case d.KnownAfterApply:
+				fmt.Fprintf(&b, "  %s %s: %s\n", d.Op, d.Path, KnownAfterApply)
+			case d.Op == "+":
+				fmt.Fprintf(&b, "  + %s: %s\n", d.Path, planValue(d.After))
+			case d.Op == "-":
+				fmt.Fprintf(&b, "  - %s: %s\n", d.Path, planValue(d.Before))
+			default:
+				fmt.Fprintf(&b, "  ~ %s: %s -> %s\n", d.Path, planValue(d.Before), planValue(d.After))
+			}
+		}
+		for _, warning := range step.Warnings {
+			fmt.Fprintf(&b, "    warning: %s\n", warning)
+		}
+		if step.Error != "" {
+			fmt.Fprintf(&b, "    error: %s\n", step.Error)
+		}
+	}
+
+	add, change, destroy, run := p.Summary()
+	fmt.Fprintf(&b, "\nPlan: %d to add, %d to change, %d to destroy, %d to run.\n", add, change, destroy, run)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func planValue(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
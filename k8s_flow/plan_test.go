@@ -0,0 +1,184 @@
+package k8s_flow
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/tools/flow"
+)
+
+func TestConcreteValue(t *testing.T) {
+	v := cuecontext.New().CompileString(`
+upstream: string
+metadata: {
+	name:      "demo"
+	namespace: *"default" | string
+	labels: app: upstream
+}
+spec: {
+	replicas: 2
+	ports: [80, 443]
+	containers: [{name: "app", image: upstream}]
+	env: [{name: "A", value: "1"}, upstream]
+}
+`)
+	var unknown []string
+	got := concreteValue(v, "", &unknown)
+	want := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      "demo",
+			"namespace": "default",
+		},
+		"spec": map[string]interface{}{
+			"replicas":   2,
+			"ports":      []interface{}{80, 443},
+			"containers": []interface{}{map[string]interface{}{"name": "app"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("concreteValue = %#v, want %#v", got, want)
+	}
+	// 不确定的列表元素使整个列表不确定，不会留下 null 占位
+	wantUnknown := []string{"upstream", "metadata.labels.app", "spec.containers[0].image", "spec.env"}
+	if !reflect.DeepEqual(unknown, wantUnknown) {
+		t.Errorf("unknown = %v, want %v", unknown, wantUnknown)
+	}
+}
+
+func TestDiffDocuments(t *testing.T) {
+	before := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "demo", "labels": map[string]interface{}{"app": "demo"}},
+		"spec":     map[string]interface{}{"replicas": float64(1), "ports": []interface{}{float64(80)}},
+	}
+	after := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "demo"},
+		"spec":     map[string]interface{}{"replicas": int64(3), "ports": []interface{}{80, 443}, "paused": true},
+	}
+	want := []FieldDiff{
+		{Op: "-", Path: "metadata.labels.app", Before: "demo"},
+		{Op: "+", Path: "spec.paused", After: true},
+		{Op: "+", Path: "spec.ports[1]", After: int64(443)},
+		{Op: "~", Path: "spec.replicas", Before: int64(1), After: int64(3)},
+	}
+	if got := diffDocuments(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffDocuments = %#v, want %#v", got, want)
+	}
+
+	// 数字类型不同但值相同时没有变化
+	if got := diffDocuments(map[string]interface{}{"a": float64(1)}, map[string]interface{}{"a": 1}); len(got) != 0 {
+		t.Errorf("diffDocuments = %#v, want no changes", got)
+	}
+}
+
+func TestUnderAny(t *testing.T) {
+	prefixes := []string{"spec.env", "metadata.labels.app"}
+	for path, want := range map[string]bool{
+		"spec.env":            true,
+		"spec.env[0].name":    true,
+		"spec.env.x":          true,
+		"spec.envFrom":        false,
+		"metadata.labels.app": true,
+		"metadata.labels":     false,
+	} {
+		if got := underAny(path, prefixes); got != want {
+			t.Errorf("underAny(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+// planWorkflow 只包含不访问集群的节点
+const planWorkflow = `
+deploy: {
+	$task:    "sleep"
+	duration: prepare.duration
+}
+notify: {
+	$task:    "sleep"
+	duration: deploy.duration
+}
+prepare: {
+	$task:    "sleep"
+	duration: "1s"
+}
+cleanup: {
+	$task:    "sleep"
+	duration: "1s"
+	when:     false
+}
+`
+
+func TestSortTasks(t *testing.T) {
+	v := cuecontext.New().CompileString(planWorkflow)
+	c := flow.New(&flow.Config{}, v, declaredTasks)
+
+	var paths []string
+	for _, task := range sortTasks(c.Tasks()) {
+		paths = append(paths, task.Path().String())
+	}
+	// 依赖的节点在前，没有依赖关系的节点保持定义顺序
+	want := []string{"prepare", "deploy", "notify", "cleanup"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("sorted = %v, want %v", paths, want)
+	}
+}
+
+func TestBuildPlan(t *testing.T) {
+	v := cuecontext.New().CompileString(planWorkflow)
+	plan, err := BuildPlan(context.Background(), &flow.Config{}, v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []PlanStep{
+		{Path: "prepare", Kind: "sleep", Action: PlanRun},
+		{Path: "deploy", Kind: "sleep", Action: PlanRun, DependsOn: []string{"prepare"}},
+		{Path: "notify", Kind: "sleep", Action: PlanRun, DependsOn: []string{"deploy"}},
+		{Path: "cleanup", Kind: "sleep", Action: PlanSkip, Note: "when is false"},
+	}
+	if !reflect.DeepEqual(plan.Steps, want) {
+		t.Errorf("steps = %#v, want %#v", plan.Steps, want)
+	}
+
+	var b strings.Builder
+	if err := plan.Render(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"# deploy (sleep) will run",
+		"depends on: prepare",
+		"# cleanup (sleep) will be skipped",
+		"Plan: 0 to add, 0 to change, 0 to destroy, 3 to run.",
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("rendered plan does not contain %q:\n%s", s, b.String())
+		}
+	}
+}
+
+func TestBuildPlanUnknownWhen(t *testing.T) {
+	v := cuecontext.New().CompileString(`
+check: {
+	$task:    "sleep"
+	duration: "1s"
+}
+deploy: {
+	$task:    "sleep"
+	duration: "1s"
+	when:     check.duration == "2s" && check.missing
+}
+`)
+	plan, err := BuildPlan(context.Background(), &flow.Config{}, v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Steps) != 2 || plan.Steps[1].Path != "deploy" {
+		t.Fatalf("steps = %#v, want check and deploy", plan.Steps)
+	}
+	// 条件依赖运行时的值，节点照常列出
+	if step := plan.Steps[1]; step.Action != PlanRun || step.Note != "when "+KnownAfterApply {
+		t.Errorf("deploy = %s (%s), want run (when %s)", step.Action, step.Note, KnownAfterApply)
+	}
+}
@@ -0,0 +1,161 @@
+package k8s_flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"cuelang.org/go/cue"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+)
+
+// RetryPolicy 节点的重试和超时策略，对应节点上的 retry、timeout 字段
+type RetryPolicy struct {
+	// Attempts 总执行次数，1 表示不重试
+	Attempts int
+	Backoff  time.Duration
+	MaxDelay time.Duration
+	// Timeout 整个节点（包括所有重试）的超时时间，0 表示不限制
+	Timeout time.Duration
+}
+
+// parseRetryPolicy 读取节点的 retry、timeout 字段，v 需要已经合并过 schema
+func parseRetryPolicy(v cue.Value) (RetryPolicy, error) {
+	policy := RetryPolicy{Attempts: 1}
+
+	if retry := v.LookupPath(cue.ParsePath("retry")); retry.Exists() {
+		attempts, err := retry.LookupPath(cue.ParsePath("attempts")).Int64()
+		if err != nil {
+			return policy, err
+		}
+		policy.Attempts = int(attempts)
+		if policy.Backoff, err = lookupDuration(retry, "backoff"); err != nil {
+			return policy, err
+		}
+		if policy.MaxDelay, err = lookupDuration(retry, "maxDelay"); err != nil {
+			return policy, err
+		}
+	}
+
+	if timeout := v.LookupPath(cue.ParsePath("timeout")); timeout.Exists() {
+		s, err := timeout.String()
+		if err != nil {
+			return policy, err
+		}
+		if policy.Timeout, err = time.ParseDuration(s); err != nil {
+			return policy, err
+		}
+	}
+
+	return policy, nil
+}
+
+// delay 第 attempt 次失败后的等待时间，指数退避，不超过 MaxDelay，MaxDelay 为 0 时不限制
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		// 不限制 MaxDelay 且重试次数很多时继续翻倍会溢出成负数
+		if d > math.MaxInt64/2 {
+			d = math.MaxInt64
+			break
+		}
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// retryableError 标记可以重试的错误
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Retryable 把 err 标记为可重试，供自定义节点类型使用
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+// IsRetryable 429、5xx、冲突等临时错误，以及 Retryable 标记过的错误可以重试
+func IsRetryable(err error) bool {
+	var re *retryableError
+	if errors.As(err, &re) {
+		return true
+	}
+
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		code := status.Status().Code
+		if code == 429 || code >= 500 {
+			return true
+		}
+	}
+	return apierrors.IsConflict(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsInternalError(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsUnexpectedServerError(err)
+}
+
+// Attempt 一次执行记录
+type Attempt struct {
+	Number     int       `json:"number"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// runWithRetry 按策略执行 fn，超时后取消 fn 的 ctx，每次执行都通过 record 记录下来
+func runWithRetry(ctx context.Context, policy RetryPolicy, record func(Attempt), fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.Timeout)
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		a := Attempt{Number: attempt, StartedAt: time.Now()}
+		out, err := fn(ctx)
+		a.FinishedAt = time.Now()
+		if err != nil {
+			a.Error = err.Error()
+		}
+		record(a)
+
+		if err == nil {
+			return out, nil
+		}
+		if ctx.Err() != nil {
+			// 也可能是整个工作流超时，由 Run 报告
+			if policy.Timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("timed out after %v: %w", policy.Timeout, err)
+			}
+			return nil, err
+		}
+		if attempt >= policy.Attempts || !IsRetryable(err) {
+			return nil, err
+		}
+
+		delay := policy.delay(attempt)
+		klog.Warningf("attempt %d/%d failed, retry in %v: %v", attempt, policy.Attempts, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%v: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package k8s_flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"cuelang.org/go/cue/cuecontext"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"first", RetryPolicy{Backoff: time.Second, MaxDelay: 30 * time.Second}, 1, time.Second},
+		{"doubled", RetryPolicy{Backoff: time.Second, MaxDelay: 30 * time.Second}, 3, 4 * time.Second},
+		{"capped", RetryPolicy{Backoff: time.Second, MaxDelay: 30 * time.Second}, 10, 30 * time.Second},
+		{"backoff over max", RetryPolicy{Backoff: time.Minute, MaxDelay: 30 * time.Second}, 1, 30 * time.Second},
+		{"no max", RetryPolicy{Backoff: time.Second}, 4, 8 * time.Second},
+		{"no max overflow", RetryPolicy{Backoff: time.Second}, 100, math.MaxInt64},
+		{"no max just below overflow", RetryPolicy{Backoff: 1}, 63, 1 << 62},
+		{"no backoff", RetryPolicy{MaxDelay: time.Second}, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.delay(tt.attempt); got != tt.want {
+				t.Errorf("delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelayNoOverflow(t *testing.T) {
+	policy := RetryPolicy{Backoff: time.Second}
+	prev := time.Duration(0)
+	for attempt := 1; attempt <= 200; attempt++ {
+		d := policy.delay(attempt)
+		if d < prev {
+			t.Fatalf("delay(%d) = %v, less than delay(%d) = %v", attempt, d, attempt-1, prev)
+		}
+		prev = d
+	}
+}
+
+func TestParseRetryPolicy(t *testing.T) {
+	v := cuecontext.New().CompileString(`
+retry: {attempts: 3, backoff: "2s", maxDelay: "10s"}
+timeout: "1m"
+`)
+	policy, err := parseRetryPolicy(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RetryPolicy{Attempts: 3, Backoff: 2 * time.Second, MaxDelay: 10 * time.Second, Timeout: time.Minute}
+	if policy != want {
+		t.Errorf("policy = %+v, want %+v", policy, want)
+	}
+
+	// 没有 retry 字段时只执行一次
+	policy, err = parseRetryPolicy(cuecontext.New().CompileString(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy != (RetryPolicy{Attempts: 1}) {
+		t.Errorf("policy = %+v, want one attempt", policy)
+	}
+
+	if _, err = parseRetryPolicy(cuecontext.New().CompileString(`timeout: "soon"`)); err == nil {
+		t.Error("expected an error for an invalid timeout")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	gr := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"marked", Retryable(errors.New("flaky")), true},
+		{"wrapped mark", fmt.Errorf("step: %w", Retryable(errors.New("flaky"))), true},
+		{"conflict", apierrors.NewConflict(gr, "demo", errors.New("modified")), true},
+		{"too many requests", apierrors.NewTooManyRequests("slow down", 1), true},
+		{"unavailable", apierrors.NewServiceUnavailable("down"), true},
+		{"not found", apierrors.NewNotFound(gr, "demo"), false},
+		{"invalid", apierrors.NewBadRequest("bad"), false},
+		{"plain", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+	if Retryable(nil) != nil {
+		t.Error("Retryable(nil) should be nil")
+	}
+}
+
+func TestRunWithRetry(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxDelay: time.Millisecond}
+
+	var attempts []Attempt
+	calls := 0
+	out, err := runWithRetry(context.Background(), policy, func(a Attempt) { attempts = append(attempts, a) }, func(ctx context.Context) (interface{}, error) {
+		calls++
+		if calls < 3 {
+			return nil, Retryable(errors.New("flaky"))
+		}
+		return "ok", nil
+	})
+	if err != nil || out != "ok" {
+		t.Fatalf("runWithRetry = %v, %v, want ok", out, err)
+	}
+	if len(attempts) != 3 || attempts[0].Error != "flaky" || attempts[2].Error != "" || attempts[2].Number != 3 {
+		t.Errorf("unexpected attempts: %+v", attempts)
+	}
+
+	// 不可重试的错误只执行一次
+	calls = 0
+	_, err = runWithRetry(context.Background(), policy, func(Attempt) {}, func(ctx context.Context) (interface{}, error) {
+		calls++
+		return nil, errors.New("boom")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("runWithRetry = %v after %d calls, want an error after 1 call", err, calls)
+	}
+
+	// 用完次数后返回最后一次的错误
+	calls = 0
+	_, err = runWithRetry(context.Background(), policy, func(Attempt) {}, func(ctx context.Context) (interface{}, error) {
+		calls++
+		return nil, Retryable(fmt.Errorf("attempt %d", calls))
+	})
+	if err == nil || err.Error() != "attempt 3" {
+		t.Errorf("runWithRetry = %v, want the third attempt's error", err)
+	}
+}
+
+func TestRunWithRetryTimeout(t *testing.T) {
+	policy := RetryPolicy{Attempts: 5, Backoff: time.Hour, Timeout: 20 * time.Millisecond}
+	start := time.Now()
+	_, err := runWithRetry(context.Background(), policy, func(Attempt) {}, func(ctx context.Context) (interface{}, error) {
+		return nil, Retryable(errors.New("flaky"))
+	})
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("runWithRetry = %v, want a timeout", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("timeout did not interrupt the backoff")
+	}
+}
@@ -0,0 +1,482 @@
+package k8s_flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/tools/flow"
+	"k8s.io/klog/v2"
+)
+
+// TaskPhase 节点所处阶段，比 flow.State 更细，区分成功和失败
+type TaskPhase string
+
+const (
+	TaskPending   TaskPhase = "Pending"
+	TaskRunning   TaskPhase = "Running"
+	TaskSucceeded TaskPhase = "Succeeded"
+	TaskFailed    TaskPhase = "Failed"
+	TaskSkipped   TaskPhase = "Skipped"
+	// TaskCompensated 成功后又因工作流失败被补偿
+	TaskCompensated TaskPhase = "Compensated"
+	// TaskCancelled 执行被取消、超时或其他节点失败时正在运行的节点
+	TaskCancelled TaskPhase = "Cancelled"
+)
+
+// TaskStatus 节点的运行记录
+type TaskStatus struct {
+	Path       string    `json:"path"`
+	Kind       string    `json:"kind"`
+	Phase      TaskPhase `json:"phase"`
+	Attempts   []Attempt `json:"attempts,omitempty"`
+	StartedAt  time.Time `json:"startedAt,omitempty"`
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	SkipReason string    `json:"skipReason,omitempty"`
+
+	// InputHash 节点运行时输入的 hash，恢复执行时输入不变才复用结果
+	InputHash string `json:"inputHash,omitempty"`
+	// Output 节点回填的输出
+	Output json.RawMessage `json:"output,omitempty"`
+	// Resumed 结果是否来自之前的执行
+	Resumed bool `json:"resumed,omitempty"`
+
+	SkipDependents bool `json:"skipDependents,omitempty"`
+
+	// Undo 节点记录的撤销信息，工作流失败时用于缺省补偿
+	Undo []UndoRecord `json:"undo,omitempty"`
+	// Objects 节点修改过的集群对象
+	Objects []ObjectChange `json:"objects,omitempty"`
+	// Compensated 补偿是否已执行成功
+	Compensated       bool   `json:"compensated,omitempty"`
+	CompensationError string `json:"compensationError,omitempty"`
+}
+
+// RunOption Run 的可选配置
+type RunOption func(r *Run)
+
+// WithID 指定执行 ID，持久化状态时使用
+func WithID(id string) RunOption {
+	return func(r *Run) {
+		r.id = id
+	}
+}
+
+// WithStateStore 持久化执行状态，已有状态时恢复执行，跳过输入未变化且已成功的节点
+func WithStateStore(store StateStore) RunOption {
+	return func(r *Run) {
+		r.store = store
+	}
+}
+
+// WithCompensation 工作流失败后的补偿模式，缺省 CompensateNever
+func WithCompensation(mode CompensationMode) RunOption {
+	return func(r *Run) {
+		r.compensation = mode
+	}
+}
+
+// Run 一次工作流执行，在 flow.Controller 的基础上记录每个节点的状态
+type Run struct {
+	id    string
+	store StateStore
+	// previous 恢复执行时之前保存的节点状态
+	previous map[string]TaskStatus
+
+	inputHash string
+
+	compensation CompensationMode
+	exporters    []OutputExporter
+
+	gracePeriod time.Duration
+	timeout     time.Duration
+
+	approver *Approver
+
+	history HistoryStore
+	meta    RunMeta
+	// recordID 本次执行在历史中的 ID，恢复执行和 Reset 时 id 不变，每次执行的记录是独立的
+	recordID string
+
+	mu    sync.RWMutex
+	tasks map[string]*TaskStatus
+	// inflight 正在运行的节点，退出时关闭对应的 channel
+	inflight map[string]chan struct{}
+	// draining flow 已经返回，不再启动新的节点
+	draining bool
+	// completed 按完成顺序记录需要补偿的节点
+	completed []string
+	// published 每个节点最后发布的事件状态
+	published map[string]string
+	// outputs 执行成功后求得的 outputs
+	outputs json.RawMessage
+	// phase、runErr、startedAt、finishedAt 整个执行的状态，写入执行历史
+	phase      TaskPhase
+	runErr     string
+	startedAt  time.Time
+	finishedAt time.Time
+
+	events *EventLog
+
+	controller *flow.Controller
+	// graph 创建时的节点和依赖，执行期间不再读取 controller 的内部状态
+	graph []graphTask
+}
+
+// NewRun 创建工作流执行，cfg 可以为 nil，cfg.UpdateFunc 在发布事件之后调用
+func NewRun(cfg *flow.Config, v cue.Value, opts ...RunOption) *Run {
+	r := &Run{
+		tasks:        map[string]*TaskStatus{},
+		inputHash:    hashValue(v),
+		compensation: CompensateNever,
+		gracePeriod:  DefaultGracePeriod,
+		inflight:     map[string]chan struct{}{},
+		published:    map[string]string{},
+		events:       newEventLog(defaultEventHistory),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.approver == nil {
+		r.approver = NewApprover()
+	}
+
+	c := flow.Config{}
+	if cfg != nil {
+		c = *cfg
+	}
+	update := c.UpdateFunc
+	c.UpdateFunc = func(fc *flow.Controller, t *flow.Task) error {
+		if err := r.onUpdate(fc, t); err != nil {
+			return err
+		}
+		if update != nil {
+			return update(fc, t)
+		}
+		return nil
+	}
+	r.controller = flow.New(&c, v, r.taskFunc)
+	r.graph = graphTasks(r.controller)
+	return r
+}
+
+// ID 执行 ID
+func (r *Run) ID() string {
+	return r.id
+}
+
+// Approver 执行中 approve 节点和补偿审批使用的审批器
+func (r *Run) Approver() *Approver {
+	return r.approver
+}
+
+// Controller 底层的 flow.Controller
+func (r *Run) Controller() *flow.Controller {
+	return r.controller
+}
+
+// Run 执行工作流直到结束，配置了 StateStore 时先加载之前的状态。
+// ctx 取消（如收到 SIGTERM）或超过 WithTimeout 时取消所有节点，最多等待 WithGracePeriod 后返回，
+// 被取消时返回的错误满足 errors.Is(err, ErrCancelled)。
+// 开始和结束时发布 EventRun 事件，结束后关闭事件日志
+func (r *Run) Run(ctx context.Context) (err error) {
+	r.begins(ctx)
+	r.events.publish(Event{RunID: r.id, Type: EventRun, State: StateRunning})
+	defer func() {
+		e := Event{RunID: r.id, Type: EventRun, State: string(TaskSucceeded)}
+		switch {
+		case errors.Is(err, ErrCancelled):
+			e.State, e.Error = string(TaskCancelled), err.Error()
+		case err != nil:
+			e.State, e.Error = string(TaskFailed), err.Error()
+		}
+		r.ends(ctx, TaskPhase(e.State), e.Error)
+		r.events.publish(e)
+		r.events.Close()
+	}()
+
+	if r.store != nil {
+		if r.id == "" {
+			return errors.New("run id is required when state store is set")
+		}
+		if locker, ok := r.store.(StateLocker); ok {
+			lockCtx, unlock, err := locker.Lock(ctx, r.id)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+			// 失去锁后取消执行，避免和接管的进程同时执行
+			ctx = lockCtx
+		}
+
+		state, err := r.store.Load(ctx, r.id)
+		if err != nil {
+			return err
+		}
+		if state != nil {
+			if state.InputHash != r.inputHash {
+				klog.Warningf("run %s: workflow input changed, only tasks with unchanged input are resumed", r.id)
+			}
+			r.previous = map[string]TaskStatus{}
+			for _, status := range state.Tasks {
+				r.previous[status.Path] = status
+			}
+		}
+	}
+
+	runCtx, cancel := r.runContext(ctx)
+	defer cancel()
+	err = r.controller.Run(runCtx)
+	r.drain(ctx)
+	if r.cancelled(runCtx) {
+		err = r.cancelErr(ctx)
+	}
+	if err == nil {
+		// 节点都已成功，导出失败不执行补偿
+		return r.collectOutputs(ctx)
+	}
+	// 被取消不算失败，不执行补偿
+	if r.compensation == CompensateNever || ctx.Err() != nil {
+		return err
+	}
+	if cerr := r.compensate(ctx, err); cerr != nil {
+		return fmt.Errorf("%v; %v", err, cerr)
+	}
+	return err
+}
+
+// State 当前执行状态的快照
+func (r *Run) State() *RunState {
+	return &RunState{
+		ID:        r.id,
+		InputHash: r.inputHash,
+		Tasks:     r.Tasks(),
+		Outputs:   r.Outputs(),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// save 持久化当前状态，失败只记录日志，不影响节点执行。
+// 执行被取消后仍然需要保存节点的最终状态，不使用 ctx 的取消
+func (r *Run) save(ctx context.Context) {
+	if r.store == nil && r.history == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), saveTimeout)
+	defer cancel()
+	r.record(ctx)
+	if r.store == nil {
+		return
+	}
+	if err := r.store.Save(ctx, r.State()); err != nil {
+		klog.Errorf("run %s: save state failed: %v", r.id, err)
+	}
+}
+
+// Tasks 返回所有节点状态的快照，按路径排序
+func (r *Run) Tasks() []TaskStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tasks := make([]TaskStatus, 0, len(r.tasks))
+	for _, status := range r.tasks {
+		tasks = append(tasks, status.snapshot())
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Path < tasks[j].Path
+	})
+	return tasks
+}
+
+// Task 返回单个节点状态的快照
+func (r *Run) Task(path string) (TaskStatus, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	status, ok := r.tasks[path]
+	if !ok {
+		return TaskStatus{}, false
+	}
+	return status.snapshot(), true
+}
+
+func (s *TaskStatus) snapshot() TaskStatus {
+	c := *s
+	c.Attempts = append([]Attempt(nil), s.Attempts...)
+	c.Undo = append([]UndoRecord(nil), s.Undo...)
+	c.Objects = append([]ObjectChange(nil), s.Objects...)
+	return c
+}
+
+// update 在锁内修改节点状态
+func (r *Run) update(path string, fn func(status *TaskStatus)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fn(r.tasks[path])
+}
+
+// complete 记录节点完成，成功或留下了撤销信息的节点在失败时需要补偿
+func (r *Run) complete(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if status := r.tasks[path]; status.Phase == TaskSucceeded || len(status.Undo) > 0 {
+		r.completed = append(r.completed, path)
+	}
+}
+
+func (r *Run) taskFunc(v cue.Value) (flow.Runner, error) {
+	if v.Path().String() == OutputsPath {
+		return outputsRunner, nil
+	}
+	kind, err := newTask(v)
+	if err != nil || kind == nil {
+		return nil, err
+	}
+
+	path := v.Path().String()
+	r.mu.Lock()
+	// flow 在节点完成后会重新扫描，已有的记录不能覆盖
+	if _, ok := r.tasks[path]; !ok {
+		r.tasks[path] = &TaskStatus{Path: path, Kind: kind.Name, Phase: TaskPending}
+	}
+	r.mu.Unlock()
+
+	return flow.RunnerFunc(func(t *flow.Task) error {
+		finish, ok := r.begin(path)
+		if !ok {
+			return t.Context().Err()
+		}
+		defer finish()
+		defer r.save(t.Context())
+
+		inputHash := hashValue(t.Value())
+		if previous, ok := r.previous[path]; ok && previous.Phase == TaskSucceeded && previous.InputHash == inputHash {
+			klog.Infof("run %s: task %s already succeeded, resume from saved output", r.id, path)
+			r.update(path, func(status *TaskStatus) {
+				*status = previous
+				status.Resumed = true
+			})
+			r.complete(path)
+			if len(previous.Output) == 0 {
+				return nil
+			}
+			// json.RawMessage 实现了 json.Marshaler，cue 会按 json 解析，数字类型不会丢失
+			return t.Fill(previous.Output)
+		}
+
+		reason, skipDependents, err := r.skipReason(t, kind)
+		if err != nil {
+			r.update(path, func(status *TaskStatus) {
+				status.Phase = TaskFailed
+				status.Error = err.Error()
+			})
+			return err
+		}
+		if reason != "" {
+			r.update(path, func(status *TaskStatus) {
+				status.Phase = TaskSkipped
+				status.SkipReason = reason
+				status.SkipDependents = skipDependents
+			})
+			return nil
+		}
+
+		r.update(path, func(status *TaskStatus) {
+			status.Phase = TaskRunning
+			status.StartedAt = time.Now()
+			status.InputHash = inputHash
+		})
+		r.publishTask(path, StateRunning, nil)
+		r.save(t.Context())
+
+		ctx := withApprover(t.Context(), r.approver)
+		ctx = withUndo(ctx, func(u UndoRecord) {
+			r.update(path, func(status *TaskStatus) {
+				status.Undo = append(status.Undo, u)
+			})
+			// 立即保存，进程中途退出后恢复执行仍然可以补偿
+			r.save(t.Context())
+		})
+		ctx = withObjects(ctx, func(c ObjectChange) {
+			r.update(path, func(status *TaskStatus) {
+				status.Objects = append(status.Objects, c)
+			})
+			r.save(t.Context())
+		})
+		out, err := runTask(ctx, t, kind, func(a Attempt) {
+			r.update(path, func(status *TaskStatus) {
+				status.Attempts = append(status.Attempts, a)
+			})
+		})
+
+		var output json.RawMessage
+		if err == nil && out != nil {
+			output, err = json.Marshal(out)
+		}
+		r.update(path, func(status *TaskStatus) {
+			if status.Phase == TaskCancelled {
+				// 超过 grace period 已经标记为取消，之后的结果不再记录
+				return
+			}
+			status.FinishedAt = time.Now()
+			status.Phase = TaskSucceeded
+			status.Output = output
+			switch {
+			case err != nil && t.Context().Err() != nil:
+				status.Phase = TaskCancelled
+				status.Error = err.Error()
+			case err != nil:
+				status.Phase = TaskFailed
+				status.Error = err.Error()
+			}
+		})
+		r.complete(path)
+		return err
+	}), nil
+}
+
+// newTask 识别节点类型并校验输入，v 不是节点时返回 nil
+func newTask(v cue.Value) (*TaskKind, error) {
+	name, err := TaskKindOf(v)
+	if err != nil || name == "" {
+		return nil, err
+	}
+
+	kind, ok := LookupTaskKind(name)
+	if !ok {
+		return nil, errors.Newf(v.Pos(), "unknown task kind %q at %v", name, v.Path())
+	}
+	if _, err = kind.input(v); err != nil {
+		return nil, err
+	}
+	return kind, nil
+}
+
+// runTask 按重试策略执行节点，成功后回填输出并返回
+func runTask(ctx context.Context, t *flow.Task, kind *TaskKind, record func(Attempt)) (interface{}, error) {
+	in, err := kind.input(t.Value())
+	if err != nil {
+		return nil, err
+	}
+	// retry、timeout 可能引用上游节点的结果，运行时才解析
+	policy, err := parseRetryPolicy(in)
+	if err != nil {
+		return nil, errors.Wrapf(err, t.Value().Pos(), "invalid retry policy of %v", t.Path())
+	}
+
+	out, err := runWithRetry(ctx, policy, record, func(ctx context.Context) (interface{}, error) {
+		return kind.Run(ctx, in)
+	})
+	if err != nil || out == nil {
+		return nil, err
+	}
+	return out, t.Fill(out)
+}
@@ -0,0 +1,109 @@
+package k8s_flow
+
+import (
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/token"
+	"cuelang.org/go/tools/flow"
+	"k8s.io/kubectl/pkg/util/openapi"
+
+	"github.com/penk110/k8s_operator/k8s_client"
+)
+
+// ValidateSchemas 不执行工作流，用 OpenAPI schema 校验每个节点渲染出的 k8s 对象:
+// apply 节点的 object，taskFunc 不为空时没有声明类型的节点本身。
+// 报告未知字段、类型错误和缺少的必填字段，Path 为 cue 中的路径，kustomize 节点渲染出的对象以 kind-name 区分。
+// 引用上游节点结果的字段运行时才能确定，不参与校验；找不到 schema 的对象（如 CRD）给出 warning
+func ValidateSchemas(cfg *flow.Config, v cue.Value, taskFunc flow.TaskFunc, resources openapi.Resources) []LintIssue {
+	objects := taskFunc != nil
+	if taskFunc == nil {
+		taskFunc = declaredTasks
+	}
+	l := &linter{root: v, seen: map[string]bool{}}
+	c := flow.New(cfg, v, taskFunc)
+
+	for _, t := range sortTasks(c.Tasks()) {
+		kind, err := newTask(t.Value())
+		if err != nil {
+			// Lint 会报告
+			continue
+		}
+		object := t.Value()
+		switch {
+		case kind == nil && objects:
+		case kind == ApplyTask:
+			object = t.Value().LookupPath(cue.ParsePath("object"))
+		case kind == KustomizeTask:
+			// 渲染失败由 Lint 报告
+			objs, _ := renderKustomization(t.Value())
+			for _, o := range objs {
+				l.validateRendered(resources, t, o)
+			}
+			continue
+		default:
+			continue
+		}
+		l.validateObject(resources, object)
+	}
+	return l.issues
+}
+
+// CheckSchemas 执行 ValidateSchemas，有 error 级别的问题时返回 *LintFailed
+func CheckSchemas(cfg *flow.Config, v cue.Value, taskFunc flow.TaskFunc, resources openapi.Resources) error {
+	return LintErr(ValidateSchemas(cfg, v, taskFunc, resources))
+}
+
+func (l *linter) validateObject(resources openapi.Resources, object cue.Value) {
+	var unknown []string
+	local, _ := concreteValue(object, "", &unknown).(map[string]interface{})
+	if local == nil {
+		return
+	}
+
+	errs, ok := k8s_client.ValidateObject(resources, local)
+	if !ok {
+		l.add(LintWarning, object.Path().String(), object.Pos(), "no schema for %v %v, skipped", local["apiVersion"], local["kind"])
+		return
+	}
+	for _, e := range errs {
+		// 缺少的必填字段可能引用了上游节点的结果
+		if underAny(e.Path, unknown) {
+			continue
+		}
+		sels := object.Path().Selectors()
+		for _, part := range k8s_client.SplitFieldPath(e.Path) {
+			switch x := part.(type) {
+			case int:
+				sels = append(sels, cue.Index(x))
+			case string:
+				sels = append(sels, cue.Str(x))
+			}
+		}
+		path := cue.MakePath(sels...)
+		l.add(LintError, path.String(), l.closestPos(path, object), "%s", e.Message)
+	}
+}
+
+// validateRendered 校验 kustomize 渲染出的对象，对象不在 cue 中，位置使用节点的 dir 字段
+func (l *linter) validateRendered(resources openapi.Resources, t *flow.Task, o kustomizeObject) {
+	dir := t.Value().LookupPath(cue.ParsePath("dir"))
+	path := joinPath(t.Path().String(), o.key)
+	errs, ok := k8s_client.ValidateObject(resources, o.obj.Object)
+	if !ok {
+		l.add(LintWarning, path, dir.Pos(), "no schema for %s %s, skipped", o.obj.GetAPIVersion(), o.obj.GetKind())
+		return
+	}
+	for _, e := range errs {
+		l.add(LintError, joinPath(path, e.Path), dir.Pos(), "%s", e.Message)
+	}
+}
+
+// closestPos path 的源码位置，字段不存在时（如缺少必填字段）使用最近的上级字段
+func (l *linter) closestPos(path cue.Path, fallback cue.Value) token.Pos {
+	sels := path.Selectors()
+	for i := len(sels); i > 0; i-- {
+		if v := l.root.LookupPath(cue.MakePath(sels[:i]...)); v.Exists() && v.Pos().IsValid() {
+			return v.Pos()
+		}
+	}
+	return fallback.Pos()
+}
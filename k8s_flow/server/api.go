@@ -0,0 +1,353 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/penk110/k8s_operator/k8s_flow"
+)
+
+// createRequest POST /api/runs 的请求体
+type createRequest struct {
+	Template string `json:"template" binding:"required"`
+	// Inputs 合并到模板的 inputs，不满足模板声明的 schema 时返回 422 和每个错误的位置
+	// 整数解码为 int64，见 k8s_flow.Inputs
+	Inputs k8s_flow.Inputs `json:"inputs"`
+	// Start 创建后立即开始
+	Start bool `json:"start"`
+}
+
+// approvalRequest POST /api/runs/:id/approve、reject 的请求体
+type approvalRequest struct {
+	// Name approve 节点的 name，或补偿审批 k8s_flow.CompensateApproval
+	Name   string `json:"name" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+// Register 在 r 上注册工作流 REST API：
+//
+//	GET    /api/templates                  模板列表
+//	POST   /api/runs                       根据模板和 inputs 创建执行
+//	GET    /api/runs                       执行列表，可以用 ?template=、?phase= 过滤
+//	GET    /api/runs/:id                   执行详情，包含每个节点的状态
+//	DELETE /api/runs/:id                   取消并删除执行
+//	POST   /api/runs/:id/start             开始执行
+//	POST   /api/runs/:id/cancel            取消执行
+//	POST   /api/runs/:id/reset             用相同的模板和参数重新创建
+//	GET    /api/runs/:id/tasks/*path       单个节点的状态
+//	GET    /api/runs/:id/approvals         执行中等待的审批
+//	POST   /api/runs/:id/approve           审批通过，{"name": "..."}，审批人为请求的用户
+//	POST   /api/runs/:id/reject            审批拒绝，{"name": "...", "reason": "..."}
+//	GET    /api/runs/:id/outputs           执行成功后的 outputs，?format=yaml 时返回 YAML 文本
+//	GET    /api/runs/:id/graph             依赖图，?format=dot|mermaid
+//	GET    /api/runs/:id/events            节点状态变化，Server-Sent Events
+//	GET    /api/runs/:id/ws                节点状态变化，WebSocket，?after= 补发之后的事件
+//	GET    /api/history                    执行历史，可以用 ?workflow=、?user=、?phase=、?object=kind/[namespace/]name、
+//	                                       ?since=、?until=（RFC3339、2006-01-02 或 24h 表示之前多久）、?limit= 过滤
+//	GET    /api/history/:id                单次执行的审计记录，包含每个节点修改的对象
+//
+// 创建执行的用户取自认证代理设置的 X-Remote-User 或 X-Forwarded-User，都没有时记为 anonymous@客户端地址。
+// registry.History 为空时执行历史的接口返回 404
+func Register(r gin.IRouter, registry *Registry) {
+	api := r.Group("/api")
+
+	api.GET("/templates", func(c *gin.Context) {
+		ok(c, http.StatusOK, registry.Templates())
+	})
+
+	api.POST("/runs", func(c *gin.Context) {
+		req := createRequest{}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			fail(c, http.StatusBadRequest, err)
+			return
+		}
+		info, err := registry.Create(req.Template, req.Inputs, requestUser(c))
+		if inputErr := (*k8s_flow.InputError)(nil); errors.As(err, &inputErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"code": http.StatusUnprocessableEntity,
+				"msg":  inputErr.Error(),
+				"data": inputErr.Details(),
+			})
+			return
+		}
+		if lintErr := (*k8s_flow.LintFailed)(nil); errors.As(err, &lintErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"code": http.StatusUnprocessableEntity,
+				"msg":  lintErr.Error(),
+				"data": lintErr.Issues,
+			})
+			return
+		}
+		if err != nil {
+			fail(c, statusOf(err, http.StatusBadRequest), err)
+			return
+		}
+		if req.Start {
+			if info, err = registry.Start(info.ID); err != nil {
+				fail(c, statusOf(err, http.StatusInternalServerError), err)
+				return
+			}
+		}
+		ok(c, http.StatusCreated, info)
+	})
+
+	api.GET("/runs", func(c *gin.Context) {
+		template, phase := c.Query("template"), c.Query("phase")
+		runs := []RunInfo{}
+		for _, info := range registry.List() {
+			if (template == "" || info.Template == template) && (phase == "" || string(info.Phase) == phase) {
+				runs = append(runs, info)
+			}
+		}
+		ok(c, http.StatusOK, runs)
+	})
+
+	api.GET("/runs/:id", func(c *gin.Context) {
+		info, err := registry.Get(c.Param("id"))
+		if err != nil {
+			fail(c, statusOf(err, http.StatusInternalServerError), err)
+			return
+		}
+		ok(c, http.StatusOK, info)
+	})
+
+	api.DELETE("/runs/:id", func(c *gin.Context) {
+		if err := registry.Delete(c.Param("id")); err != nil {
+			fail(c, statusOf(err, http.StatusInternalServerError), err)
+			return
+		}
+		ok(c, http.StatusOK, nil)
+	})
+
+	actions := map[string]func(id string) (RunInfo, error){
+		"start":  registry.Start,
+		"cancel": registry.Cancel,
+		"reset":  registry.Reset,
+	}
+	for name, action := range actions {
+		action := action
+		api.POST("/runs/:id/"+name, func(c *gin.Context) {
+			info, err := action(c.Param("id"))
+			if err != nil {
+				fail(c, statusOf(err, http.StatusInternalServerError), err)
+				return
+			}
+			ok(c, http.StatusOK, info)
+		})
+	}
+
+	api.GET("/runs/:id/tasks/*path", func(c *gin.Context) {
+		run, err := registry.Run(c.Param("id"))
+		if err != nil {
+			fail(c, statusOf(err, http.StatusInternalServerError), err)
+			return
+		}
+		path := strings.TrimPrefix(c.Param("path"), "/")
+		status, found := run.Task(path)
+		if !found {
+			fail(c, http.StatusNotFound, errors.New("task "+path+" not found"))
+			return
+		}
+		ok(c, http.StatusOK, status)
+	})
+
+	api.GET("/runs/:id/approvals", func(c *gin.Context) {
+		run, err := registry.Run(c.Param("id"))
+		if err != nil {
+			fail(c, statusOf(err, http.StatusInternalServerError), err)
+			return
+		}
+		ok(c, http.StatusOK, run.Approver().Pending())
+	})
+
+	for _, approve := range []bool{true, false} {
+		approve := approve
+		action := "reject"
+		if approve {
+			action = "approve"
+		}
+		api.POST("/runs/:id/"+action, func(c *gin.Context) {
+			req := approvalRequest{}
+			if err := c.ShouldBindJSON(&req); err != nil {
+				fail(c, http.StatusBadRequest, err)
+				return
+			}
+			run, err := registry.Run(c.Param("id"))
+			if err != nil {
+				fail(c, statusOf(err, http.StatusInternalServerError), err)
+				return
+			}
+			if approve {
+				run.Approver().Approve(req.Name, requestUser(c))
+			} else {
+				run.Approver().Reject(req.Name, requestUser(c), req.Reason)
+			}
+			ok(c, http.StatusOK, nil)
+		})
+	}
+
+	api.GET("/runs/:id/outputs", func(c *gin.Context) {
+		run, err := registry.Run(c.Param("id"))
+		if err != nil {
+			fail(c, statusOf(err, http.StatusInternalServerError), err)
+			return
+		}
+		outputs := run.Outputs()
+		if outputs == nil {
+			fail(c, http.StatusNotFound, errors.New("outputs are not available until the run succeeds"))
+			return
+		}
+		if c.Query("format") == "yaml" {
+			data, err := k8s_flow.FormatOutputs(outputs, "yaml")
+			if err != nil {
+				fail(c, http.StatusInternalServerError, err)
+				return
+			}
+			c.Data(http.StatusOK, "application/yaml", data)
+			return
+		}
+		ok(c, http.StatusOK, outputs)
+	})
+
+	api.GET("/runs/:id/graph", func(c *gin.Context) {
+		run, err := registry.Run(c.Param("id"))
+		if err != nil {
+			fail(c, statusOf(err, http.StatusInternalServerError), err)
+			return
+		}
+		graph := run.Graph()
+		switch c.Query("format") {
+		case "dot":
+			c.String(http.StatusOK, graph.DOT())
+		case "mermaid":
+			c.String(http.StatusOK, graph.Mermaid())
+		default:
+			ok(c, http.StatusOK, graph)
+		}
+	})
+
+	api.GET("/runs/:id/events", func(c *gin.Context) {
+		run, err := registry.Run(c.Param("id"))
+		if err != nil {
+			fail(c, statusOf(err, http.StatusInternalServerError), err)
+			return
+		}
+		ServeSSE(c, run.Events())
+	})
+
+	api.GET("/runs/:id/ws", func(c *gin.Context) {
+		run, err := registry.Run(c.Param("id"))
+		if err != nil {
+			fail(c, statusOf(err, http.StatusInternalServerError), err)
+			return
+		}
+		ServeWebSocket(c, run.Events())
+	})
+
+	api.GET("/history", func(c *gin.Context) {
+		if registry.History == nil {
+			fail(c, http.StatusNotFound, errors.New("run history is not enabled"))
+			return
+		}
+		filter, err := historyFilter(c)
+		if err != nil {
+			fail(c, http.StatusBadRequest, err)
+			return
+		}
+		records, err := registry.History.List(c.Request.Context(), filter)
+		if err != nil {
+			fail(c, http.StatusInternalServerError, err)
+			return
+		}
+		ok(c, http.StatusOK, records)
+	})
+
+	api.GET("/history/:id", func(c *gin.Context) {
+		if registry.History == nil {
+			fail(c, http.StatusNotFound, errors.New("run history is not enabled"))
+			return
+		}
+		record, err := registry.History.Get(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			fail(c, http.StatusInternalServerError, err)
+			return
+		}
+		if record == nil {
+			fail(c, http.StatusNotFound, errors.New("run record "+c.Param("id")+" not found"))
+			return
+		}
+		ok(c, http.StatusOK, record)
+	})
+}
+
+// requestUser 发起请求的用户，由前面的认证代理设置
+func requestUser(c *gin.Context) string {
+	for _, header := range []string{"X-Remote-User", "X-Forwarded-User"} {
+		if user := c.GetHeader(header); user != "" {
+			return user
+		}
+	}
+	return "anonymous@" + c.ClientIP()
+}
+
+// historyFilter 解析 GET /api/history 的查询参数
+func historyFilter(c *gin.Context) (k8s_flow.HistoryFilter, error) {
+	filter := k8s_flow.HistoryFilter{
+		Workflow: c.Query("workflow"),
+		User:     c.Query("user"),
+		Phase:    k8s_flow.TaskPhase(c.Query("phase")),
+		Object:   c.Query("object"),
+	}
+	now := time.Now()
+	var err error
+	if since := c.Query("since"); since != "" {
+		if filter.Since, err = k8s_flow.ParseHistoryTime(since, now); err != nil {
+			return filter, err
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		if filter.Until, err = k8s_flow.ParseHistoryTime(until, now); err != nil {
+			return filter, err
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	return filter, nil
+}
+
+// statusOf 根据 Registry 返回的错误确定 http 状态码
+func statusOf(err error, fallback int) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	default:
+		return fallback
+	}
+}
+
+func ok(c *gin.Context, status int, data interface{}) {
+	c.JSON(status, gin.H{
+		"code": 0,
+		"msg":  "ok",
+		"data": data,
+	})
+}
+
+func fail(c *gin.Context, status int, err error) {
+	c.JSON(status, gin.H{
+		"code": status,
+		"msg":  err.Error(),
+		"data": nil,
+	})
+}
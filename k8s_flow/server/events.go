@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"k8s.io/klog/v2"
+
+	"github.com/penk110/k8s_operator/k8s_flow"
+)
+
+// heartbeatInterval 没有事件时定期发送心跳，避免代理断开空闲连接
+const heartbeatInterval = 15 * time.Second
+
+var upgrader = websocket.Upgrader{
+	// 页面和 API 可能不同源，由外层的网关做鉴权
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// lastEventID 断线重连时客户端已经收到的最后一个事件，
+// SSE 由浏览器通过 Last-Event-ID 头带上，WebSocket 通过 ?after= 带上
+func lastEventID(c *gin.Context) int64 {
+	id := c.GetHeader("Last-Event-ID")
+	if id == "" {
+		id = c.Query("after")
+	}
+	after, _ := strconv.ParseInt(id, 10, 64)
+	return after
+}
+
+// ServeSSE 以 Server-Sent Events 推送 events 的事件，先补发 Last-Event-ID 之后的历史事件。
+// 执行结束后发送 end 事件，客户端收到后应当关闭连接，不再重连
+func ServeSSE(c *gin.Context, events *k8s_flow.EventLog) {
+	replay, ch, cancel := events.Subscribe(lastEventID(c))
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+
+	write := func(e k8s_flow.Event) bool {
+		data, _ := json.Marshal(e)
+		_, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+		c.Writer.Flush()
+		return err == nil
+	}
+	for _, e := range replay {
+		if !write(e) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case e, ok := <-ch:
+			if !ok {
+				// 消费太慢被断开时直接结束，浏览器会带上 Last-Event-ID 重连补发
+				if events.Closed() {
+					fmt.Fprint(c.Writer, "event: end\ndata: {}\n\n")
+					c.Writer.Flush()
+				}
+				return
+			}
+			if !write(e) {
+				return
+			}
+		}
+	}
+}
+
+// ServeWebSocket 以 WebSocket 推送 events 的事件，每条消息是一个 JSON 编码的 Event，
+// 先补发 ?after= 之后的历史事件，执行结束后正常关闭连接
+func ServeWebSocket(c *gin.Context, events *k8s_flow.EventLog) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		klog.Errorf("websocket upgrade err: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	replay, ch, cancel := events.Subscribe(lastEventID(c))
+	defer cancel()
+
+	// 客户端不会发送消息，读协程只用于感知连接断开
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, e := range replay {
+		if err := conn.WriteJSON(e); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		case e, ok := <-ch:
+			if !ok {
+				code, text := websocket.CloseTryAgainLater, "too slow, reconnect with ?after="
+				if events.Closed() {
+					code, text = websocket.CloseNormalClosure, "run finished"
+				}
+				_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+				return
+			}
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/tools/flow"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/klog/v2"
+
+	"github.com/penk110/k8s_operator/k8s_flow"
+)
+
+// RunPhase 一次执行的阶段
+type RunPhase string
+
+const (
+	RunCreated   RunPhase = "Created"
+	RunRunning   RunPhase = "Running"
+	RunSucceeded RunPhase = "Succeeded"
+	RunFailed    RunPhase = "Failed"
+	RunCancelled RunPhase = "Cancelled"
+)
+
+var (
+	ErrNotFound = fmt.Errorf("not found")
+	ErrConflict = fmt.Errorf("conflict")
+)
+
+// Template 工作流模板，每次创建执行时在新的 cue context 中编译
+type Template struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	// Root 只在该路径下查找节点
+	Root string `json:"root,omitempty"`
+}
+
+// RunInfo 执行的快照
+type RunInfo struct {
+	ID       string                 `json:"id"`
+	Template string                 `json:"template"`
+	Inputs   map[string]interface{} `json:"inputs,omitempty"`
+	// User 创建执行的用户，Reset 后不变
+	User       string                `json:"user,omitempty"`
+	Phase      RunPhase              `json:"phase"`
+	Error      string                `json:"error,omitempty"`
+	CreatedAt  time.Time             `json:"createdAt"`
+	StartedAt  *time.Time            `json:"startedAt,omitempty"`
+	FinishedAt *time.Time            `json:"finishedAt,omitempty"`
+	Tasks      []k8s_flow.TaskStatus `json:"tasks,omitempty"`
+	// Outputs 执行成功后模板 outputs 的值
+	Outputs json.RawMessage `json:"outputs,omitempty"`
+}
+
+// instance 一次执行，字段由 Registry.mu 保护
+type instance struct {
+	info   RunInfo
+	run    *k8s_flow.Run
+	cancel context.CancelFunc
+	// done 执行结束后关闭，未开始时为空
+	done chan struct{}
+}
+
+// Registry 模板和执行的注册表，可以被多个请求并发访问，每次执行互不影响
+type Registry struct {
+	// Options 创建每个执行时附加的选项，如 WithGracePeriod
+	Options []k8s_flow.RunOption
+	// State 不为空时持久化每个执行的状态，Reset 时删除
+	State k8s_flow.StateStore
+	// History 不为空时每次执行写入执行历史，来源为 api
+	History k8s_flow.HistoryStore
+
+	mu        sync.RWMutex
+	templates map[string]Template
+	runs      map[string]*instance
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		templates: map[string]Template{},
+		runs:      map[string]*instance{},
+	}
+}
+
+// AddTemplate 注册模板，注册前先编译检查
+func (r *Registry) AddTemplate(t Template) error {
+	if v := cuecontext.New().CompileString(t.Source, cue.Filename(t.Name+".cue")); v.Err() != nil {
+		return fmt.Errorf("compile template %s: %w", t.Name, v.Err())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.templates[t.Name] = t
+	return nil
+}
+
+// Templates 已注册的模板，按名称排序
+func (r *Registry) Templates() []Template {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	templates := make([]Template, 0, len(r.templates))
+	for _, t := range r.templates {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+// build 在新的 cue context 中编译模板、合并参数并做静态检查，cue context 不能在多个执行之间并发使用
+func (r *Registry) build(id string, t Template, inputs map[string]interface{}, user string) (*k8s_flow.Run, error) {
+	v := cuecontext.New().CompileString(t.Source, cue.Filename(t.Name+".cue"))
+	v, err := k8s_flow.ApplyInputs(v, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &flow.Config{}
+	if t.Root != "" {
+		cfg.Root = cue.ParsePath(t.Root)
+	}
+	if err = k8s_flow.CheckLint(cfg, v, nil); err != nil {
+		return nil, err
+	}
+	opts := append([]k8s_flow.RunOption{k8s_flow.WithID(id)}, r.Options...)
+	if r.State != nil {
+		opts = append(opts, k8s_flow.WithStateStore(r.State))
+	}
+	if r.History != nil {
+		opts = append(opts, k8s_flow.WithHistory(r.History, k8s_flow.RunMeta{
+			Workflow: t.Name,
+			User:     user,
+			Trigger:  "api",
+			Params:   inputs,
+		}))
+	}
+	return k8s_flow.NewRun(cfg, v, opts...), nil
+}
+
+// Create 根据模板和参数创建执行，不会自动开始，user 写入执行历史
+func (r *Registry) Create(template string, inputs map[string]interface{}, user string) (RunInfo, error) {
+	r.mu.RLock()
+	t, ok := r.templates[template]
+	r.mu.RUnlock()
+	if !ok {
+		return RunInfo{}, fmt.Errorf("template %s: %w", template, ErrNotFound)
+	}
+
+	id := fmt.Sprintf("%s-%s", template, rand.String(5))
+	run, err := r.build(id, t, inputs, user)
+	if err != nil {
+		return RunInfo{}, err
+	}
+
+	inst := &instance{
+		info: RunInfo{
+			ID:        id,
+			Template:  template,
+			Inputs:    inputs,
+			User:      user,
+			Phase:     RunCreated,
+			CreatedAt: time.Now(),
+		},
+		run: run,
+	}
+	r.mu.Lock()
+	r.runs[id] = inst
+	r.mu.Unlock()
+	klog.Infof("run %s created from template %s by %s", id, template, user)
+	return r.snapshot(inst), nil
+}
+
+// Start 开始执行，已经开始过的执行需要先 Reset
+func (r *Registry) Start(id string) (RunInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	inst, ok := r.runs[id]
+	if !ok {
+		return RunInfo{}, fmt.Errorf("run %s: %w", id, ErrNotFound)
+	}
+	if inst.info.Phase != RunCreated {
+		return RunInfo{}, fmt.Errorf("run %s is %s, reset it before starting again: %w", id, inst.info.Phase, ErrConflict)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	inst.cancel = cancel
+	inst.done = make(chan struct{})
+	inst.info.Phase = RunRunning
+	inst.info.StartedAt = &now
+
+	run, done := inst.run, inst.done
+	go func() {
+		defer close(done)
+		defer cancel()
+		err := run.Run(ctx)
+		r.finish(id, run, err)
+	}()
+	return r.snapshotLocked(inst), nil
+}
+
+// finish 记录执行结果，执行期间被 Reset 时 instance 已经替换，忽略旧的结果
+func (r *Registry) finish(id string, run *k8s_flow.Run, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	inst, ok := r.runs[id]
+	if !ok || inst.run != run {
+		return
+	}
+	now := time.Now()
+	inst.info.FinishedAt = &now
+	switch {
+	case errors.Is(err, k8s_flow.ErrCancelled):
+		inst.info.Phase = RunCancelled
+	case err != nil:
+		inst.info.Phase = RunFailed
+		inst.info.Error = err.Error()
+		klog.Errorf("run %s failed: %v", id, err)
+	default:
+		inst.info.Phase = RunSucceeded
+	}
+}
+
+// Cancel 取消执行并等待退出，正在运行的节点最多等待 grace period（见 k8s_flow.WithGracePeriod），
+// 已经结束的执行再次取消直接返回当前状态
+func (r *Registry) Cancel(id string) (RunInfo, error) {
+	r.mu.RLock()
+	inst, ok := r.runs[id]
+	var cancel context.CancelFunc
+	var done chan struct{}
+	if ok {
+		cancel, done = inst.cancel, inst.done
+	}
+	r.mu.RUnlock()
+	if !ok {
+		return RunInfo{}, fmt.Errorf("run %s: %w", id, ErrNotFound)
+	}
+	if cancel == nil {
+		return RunInfo{}, fmt.Errorf("run %s is not started: %w", id, ErrConflict)
+	}
+
+	cancel()
+	<-done
+	return r.snapshot(inst), nil
+}
+
+// Reset 取消正在进行的执行，删除保存的状态后用相同的模板和参数重新创建，执行 ID 不变，所有节点重新执行
+func (r *Registry) Reset(id string) (RunInfo, error) {
+	r.mu.RLock()
+	inst, ok := r.runs[id]
+	started := ok && inst.cancel != nil
+	r.mu.RUnlock()
+	if !ok {
+		return RunInfo{}, fmt.Errorf("run %s: %w", id, ErrNotFound)
+	}
+	if started {
+		if _, err := r.Cancel(id); err != nil {
+			return RunInfo{}, err
+		}
+	}
+
+	r.mu.RLock()
+	t, ok := r.templates[inst.info.Template]
+	r.mu.RUnlock()
+	if !ok {
+		return RunInfo{}, fmt.Errorf("template %s: %w", inst.info.Template, ErrNotFound)
+	}
+	if r.State != nil {
+		if err := r.State.Delete(context.Background(), id); err != nil {
+			return RunInfo{}, fmt.Errorf("delete state of run %s: %v", id, err)
+		}
+	}
+	run, err := r.build(id, t, inst.info.Inputs, inst.info.User)
+	if err != nil {
+		return RunInfo{}, err
+	}
+
+	fresh := &instance{
+		info: RunInfo{
+			ID:        id,
+			Template:  inst.info.Template,
+			Inputs:    inst.info.Inputs,
+			User:      inst.info.User,
+			Phase:     RunCreated,
+			CreatedAt: time.Now(),
+		},
+		run: run,
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runs[id] != inst {
+		return RunInfo{}, fmt.Errorf("run %s was modified concurrently: %w", id, ErrConflict)
+	}
+	// 没有开始过的执行不会自己关闭事件日志，订阅方需要重新订阅新的执行
+	inst.run.Events().Close()
+	r.runs[id] = fresh
+	return r.snapshotLocked(fresh), nil
+}
+
+// Delete 取消并删除执行
+func (r *Registry) Delete(id string) error {
+	r.mu.RLock()
+	inst, ok := r.runs[id]
+	var cancel context.CancelFunc
+	var done chan struct{}
+	if ok {
+		cancel, done = inst.cancel, inst.done
+	}
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("run %s: %w", id, ErrNotFound)
+	}
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	inst.run.Events().Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runs[id] == inst {
+		delete(r.runs, id)
+	}
+	return nil
+}
+
+// Get 执行的快照，包含每个节点的状态
+func (r *Registry) Get(id string) (RunInfo, error) {
+	r.mu.RLock()
+	inst, ok := r.runs[id]
+	r.mu.RUnlock()
+	if !ok {
+		return RunInfo{}, fmt.Errorf("run %s: %w", id, ErrNotFound)
+	}
+	return r.snapshot(inst), nil
+}
+
+// Run 执行对应的 k8s_flow.Run，用于获取依赖图等
+func (r *Registry) Run(id string) (*k8s_flow.Run, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	inst, ok := r.runs[id]
+	if !ok {
+		return nil, fmt.Errorf("run %s: %w", id, ErrNotFound)
+	}
+	return inst.run, nil
+}
+
+// List 所有执行的快照，按创建时间倒序，不包含节点详情
+func (r *Registry) List() []RunInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	runs := make([]RunInfo, 0, len(r.runs))
+	for _, inst := range r.runs {
+		info := r.snapshotLocked(inst)
+		info.Tasks = nil
+		runs = append(runs, info)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].CreatedAt.After(runs[j].CreatedAt) })
+	return runs
+}
+
+// Shutdown 取消所有正在进行的执行并等待退出
+func (r *Registry) Shutdown() {
+	r.mu.RLock()
+	var running []*instance
+	for _, inst := range r.runs {
+		if inst.cancel != nil {
+			running = append(running, inst)
+		}
+	}
+	r.mu.RUnlock()
+
+	// 开始后 cancel、done 不再修改，先全部取消再等待，总耗时不超过一个 grace period
+	for _, inst := range running {
+		inst.cancel()
+	}
+	for _, inst := range running {
+		<-inst.done
+	}
+}
+
+func (r *Registry) snapshot(inst *instance) RunInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.snapshotLocked(inst)
+}
+
+func (r *Registry) snapshotLocked(inst *instance) RunInfo {
+	info := inst.info
+	info.Tasks = inst.run.Tasks()
+	info.Outputs = inst.run.Outputs()
+	return info
+}
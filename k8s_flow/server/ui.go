@@ -0,0 +1,31 @@
+package server
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+//go:embed web/*.html
+var webFS embed.FS
+
+var pages = template.Must(template.ParseFS(webFS, "web/*.html"))
+
+// RegisterUI 在 r 上注册页面：/ 列出所有执行和模板，?id= 查看某个执行的节点、输出和依赖图，
+// 页面通过 Register 注册的 REST API 操作执行，页面随二进制一起发布，不依赖工作目录
+func RegisterUI(r gin.IRouter, registry *Registry) {
+	r.GET("/", func(c *gin.Context) {
+		data := gin.H{"runs": registry.List(), "templates": registry.Templates()}
+		if id := c.Query("id"); id != "" {
+			if info, err := registry.Get(id); err == nil {
+				run, _ := registry.Run(id)
+				data["run"] = info
+				data["graph"] = run.Graph().Mermaid()
+			}
+		}
+		c.Render(http.StatusOK, render.HTML{Template: pages, Name: "index.html", Data: data})
+	})
+}
@@ -0,0 +1,119 @@
+package k8s_flow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cuelang.org/go/cue"
+)
+
+// RunState 持久化的工作流执行状态，用于进程重启后继续执行
+type RunState struct {
+	ID string `json:"id"`
+	// InputHash 整个工作流 cue 输入的 hash
+	InputHash string       `json:"inputHash"`
+	Tasks     []TaskStatus `json:"tasks"`
+	// Outputs 执行成功后求得的 outputs
+	Outputs   json.RawMessage `json:"outputs,omitempty"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// StateStore 执行状态的存储后端，Load 在状态不存在时返回 nil, nil
+type StateStore interface {
+	Load(ctx context.Context, runID string) (*RunState, error)
+	Save(ctx context.Context, state *RunState) error
+	Delete(ctx context.Context, runID string) error
+}
+
+// StateLocker 可选的接口，存储后端实现后同一个执行同时只能被一个进程恢复。
+// 返回的 ctx 在失去锁时取消（cause 为 ErrLockLost），执行使用该 ctx，另外返回释放锁的函数
+type StateLocker interface {
+	Lock(ctx context.Context, runID string) (context.Context, func(), error)
+}
+
+// ErrLockLost 执行过程中没能续约锁，其他进程可能已经接管
+var ErrLockLost = errors.New("run lock lost")
+
+// hashValue cue 值的 hash，用于判断输入是否发生变化
+func hashValue(v cue.Value) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%v", v)))
+	return hex.EncodeToString(sum[:])
+}
+
+// FileStore 本地文件存储，每个执行一个 json 文件，不支持跨进程加锁
+type FileStore struct {
+	Dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{Dir: dir}, nil
+}
+
+func (s *FileStore) path(runID string) string {
+	return filepath.Join(s.Dir, runID+".json")
+}
+
+func (s *FileStore) Load(ctx context.Context, runID string) (*RunState, error) {
+	data, err := os.ReadFile(s.path(runID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &RunState{}
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("decode run state %s: %v", runID, err)
+	}
+	return state, nil
+}
+
+// Save 先写临时文件再 rename，进程中途退出不会留下写了一半的状态
+func (s *FileStore) Save(ctx context.Context, state *RunState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path(state.ID), data)
+}
+
+// writeFile 先写同目录下的临时文件再 rename
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileStore) Delete(ctx context.Context, runID string) error {
+	err := os.Remove(s.path(runID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,199 @@
+package k8s_flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	stateConfigMapKey    = "state.json"
+	stateNamePrefix      = "k8sflow-run-"
+	defaultLeaseDuration = 30 * time.Second
+)
+
+// ConfigMapStore 把执行状态保存在 ConfigMap 中，并通过同名 Lease 保证同一时间只有一个进程执行。
+// ConfigMap 大小上限 1MiB，输出很大的节点（如 get 大量对象）不适合使用
+type ConfigMapStore struct {
+	Client    kubernetes.Interface
+	Namespace string
+	// Identity Lease 的持有者标识，缺省为 hostname
+	Identity      string
+	LeaseDuration time.Duration
+	// OwnerReferences 创建 ConfigMap 和 Lease 时设置，如 operator 设置为 Workflow，删除 Workflow 时一起回收
+	OwnerReferences []metav1.OwnerReference
+}
+
+func NewConfigMapStore(client kubernetes.Interface, namespace string) *ConfigMapStore {
+	identity, _ := os.Hostname()
+	return &ConfigMapStore{
+		Client:        client,
+		Namespace:     namespace,
+		Identity:      identity,
+		LeaseDuration: defaultLeaseDuration,
+	}
+}
+
+func (s *ConfigMapStore) name(runID string) string {
+	return stateNamePrefix + runID
+}
+
+func (s *ConfigMapStore) Load(ctx context.Context, runID string) (*RunState, error) {
+	cm, err := s.Client.CoreV1().ConfigMaps(s.Namespace).Get(ctx, s.name(runID), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &RunState{}
+	if err = json.Unmarshal([]byte(cm.Data[stateConfigMapKey]), state); err != nil {
+		return nil, fmt.Errorf("decode run state %s: %v", runID, err)
+	}
+	return state, nil
+}
+
+func (s *ConfigMapStore) Save(ctx context.Context, state *RunState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	configMaps := s.Client.CoreV1().ConfigMaps(s.Namespace)
+	cm, err := configMaps.Get(ctx, s.name(state.ID), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            s.name(state.ID),
+				Namespace:       s.Namespace,
+				Labels:          map[string]string{"app.kubernetes.io/managed-by": "k8sflow"},
+				OwnerReferences: s.OwnerReferences,
+			},
+			Data: map[string]string{stateConfigMapKey: string(data)},
+		}
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[stateConfigMapKey] = string(data)
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+func (s *ConfigMapStore) Delete(ctx context.Context, runID string) error {
+	err := s.Client.CoreV1().ConfigMaps(s.Namespace).Delete(ctx, s.name(runID), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Lock 获取执行对应的 Lease，其他进程持有且未过期时返回错误，获取成功后后台定期续约。
+// 续约冲突（被其他进程抢占）或在 Lease 过期前一直续约失败时取消返回的 ctx
+func (s *ConfigMapStore) Lock(ctx context.Context, runID string) (context.Context, func(), error) {
+	leases := s.Client.CoordinationV1().Leases(s.Namespace)
+	name := s.name(runID)
+	duration := s.LeaseDuration
+	if duration <= 0 {
+		duration = defaultLeaseDuration
+	}
+	seconds := int32(duration.Seconds())
+	now := metav1.NewMicroTime(time.Now())
+
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: s.Namespace, OwnerReferences: s.OwnerReferences},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &s.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if lease, err = leases.Create(ctx, lease, metav1.CreateOptions{}); err != nil {
+			return nil, nil, err
+		}
+	case err != nil:
+		return nil, nil, err
+	default:
+		holder := ""
+		if lease.Spec.HolderIdentity != nil {
+			holder = *lease.Spec.HolderIdentity
+		}
+		if holder != "" && holder != s.Identity && lease.Spec.RenewTime != nil && lease.Spec.LeaseDurationSeconds != nil {
+			expire := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+			if time.Now().Before(expire) {
+				return nil, nil, fmt.Errorf("run %s is locked by %s until %v", runID, holder, expire)
+			}
+		}
+		lease.Spec.HolderIdentity = &s.Identity
+		lease.Spec.LeaseDurationSeconds = &seconds
+		lease.Spec.AcquireTime = &now
+		lease.Spec.RenewTime = &now
+		// 带 resourceVersion 更新，并发抢占时只有一个成功
+		if lease, err = leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	lockCtx, lost := context.WithCancelCause(ctx)
+	renewCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		interval := duration / 3
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-renewCtx.Done():
+				return
+			case <-ticker.C:
+				renewTime := metav1.NewMicroTime(time.Now())
+				lease.Spec.RenewTime = &renewTime
+				updated, err := leases.Update(renewCtx, lease, metav1.UpdateOptions{})
+				if err == nil {
+					lease, renewed = updated, renewTime.Time
+					continue
+				}
+				klog.Errorf("renew lease %s/%s failed: %v", s.Namespace, name, err)
+				// 冲突说明 Lease 已被修改，其他进程可能已经接管；下一次续约之前会过期时也不再继续
+				if apierrors.IsConflict(err) || apierrors.IsNotFound(err) || time.Since(renewed)+interval >= duration {
+					lost(fmt.Errorf("%w: lease %s/%s: %v", ErrLockLost, s.Namespace, name, err))
+					return
+				}
+			}
+		}
+	}()
+
+	return lockCtx, func() {
+		cancel()
+		<-done
+		lost(nil)
+		err := leases.Delete(context.Background(), name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.Errorf("release lease %s/%s failed: %v", s.Namespace, name, err)
+		}
+	}, nil
+}
@@ -0,0 +1,158 @@
+// Package k8s_flow 基于 cue tools/flow 的工作流引擎。
+//
+// 节点通过 $task 字段或 @task() 属性显式声明类型，例如
+//
+//	step1: {
+//		$task:  "apply"
+//		object: _ @manifest("../yamls/deploy.yaml")
+//	}
+//	step2: {
+//		target: {apiVersion: "apps/v1", kind: "Deployment", name: "flowdeploy"}
+//	} @task(wait)
+//
+// 内置类型: apply、kustomize、delete、wait、get、patch、scale、exec-job、http、sleep、approve、foreach，
+// 各类型的输入输出见对应 TaskKind 的 Doc。对象可以在 cue 中定义，也可以通过 @manifest 引用 YAML 清单，见 ManifestAttr。
+//
+// 配置了 WithCompensation 时，工作流失败后按完成顺序倒序补偿已完成的节点。
+// 节点可以声明 compensate 补偿节点，为 false 时不补偿，未声明时使用节点类型的缺省补偿，
+// 如 apply 删除新创建的对象或还原修改前的对象
+//
+//	step1: {
+//		$task:  "apply"
+//		object: _ @manifest("../yamls/deploy.yaml")
+//		compensate: {$task: "scale", target: {...}, replicas: 0}
+//	}
+package k8s_flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/tools/flow"
+)
+
+const (
+	// TaskMarker 节点类型标记字段，如 step1: {$task: "apply", object: {...}}
+	TaskMarker = "$task"
+	// TaskAttr 节点类型标记属性，如 step1: {object: {...}} @task(apply)
+	TaskAttr = "task"
+)
+
+// TaskKind 一种节点类型
+type TaskKind struct {
+	Name string
+	// Doc 节点说明，列出输入输出字段
+	Doc string
+	// Schema 节点输入的 cue 约束，缺省值也在这里声明。
+	// 创建节点时和节点的值合并校验，运行时再合并一次，Run 拿到的是带缺省值的结果
+	Schema string
+	// Run 执行节点，返回值回填到节点上，修改集群对象后调用 RecordObject 写入审计记录
+	Run func(ctx context.Context, v cue.Value) (interface{}, error)
+	// Compensate 可选，根据 Run 中 RecordUndo 记录的信息撤销节点的修改
+	Compensate func(ctx context.Context, data json.RawMessage) error
+	// Plan 可选，plan 时计算节点的影响写入 step，不能修改集群，没有实现时只列出节点
+	Plan func(ctx context.Context, step *PlanStep, v cue.Value)
+}
+
+var (
+	taskKindsMu sync.RWMutex
+	taskKinds   = map[string]*TaskKind{}
+)
+
+// RegisterTaskKind 注册节点类型，名称重复直接 panic
+func RegisterTaskKind(kind *TaskKind) {
+	taskKindsMu.Lock()
+	defer taskKindsMu.Unlock()
+
+	if _, ok := taskKinds[kind.Name]; ok {
+		panic(fmt.Sprintf("task kind %q already registered", kind.Name))
+	}
+	taskKinds[kind.Name] = kind
+}
+
+// LookupTaskKind 根据名称查找节点类型
+func LookupTaskKind(name string) (*TaskKind, bool) {
+	taskKindsMu.RLock()
+	defer taskKindsMu.RUnlock()
+
+	kind, ok := taskKinds[name]
+	return kind, ok
+}
+
+// TaskKinds 返回所有已注册的节点类型，按名称排序
+func TaskKinds() []*TaskKind {
+	taskKindsMu.RLock()
+	defer taskKindsMu.RUnlock()
+
+	kinds := make([]*TaskKind, 0, len(taskKinds))
+	for _, kind := range taskKinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		return kinds[i].Name < kinds[j].Name
+	})
+	return kinds
+}
+
+// TaskKindOf 返回 v 声明的节点类型名称，没有声明时返回空
+func TaskKindOf(v cue.Value) (string, error) {
+	attr := v.Attribute(TaskAttr)
+	if attr.Err() == nil {
+		name, err := attr.String(0)
+		if err != nil {
+			return "", errors.Wrapf(err, v.Pos(), "invalid @%s attribute", TaskAttr)
+		}
+		return name, nil
+	}
+
+	marker := v.LookupPath(cue.ParsePath(TaskMarker))
+	if !marker.Exists() {
+		return "", nil
+	}
+	name, err := marker.String()
+	if err != nil {
+		return "", errors.Wrapf(err, marker.Pos(), "%s must be a concrete string", TaskMarker)
+	}
+	return name, nil
+}
+
+// compileSchema 在 v 所在的 cue context 中编译节点类型的 schema
+func (k *TaskKind) compileSchema(v cue.Value) cue.Value {
+	return v.Context().CompileString(commonSchema+k.Schema, cue.Filename(k.Name+".cue"))
+}
+
+// input 合并 schema 后的节点输入
+func (k *TaskKind) input(v cue.Value) (cue.Value, error) {
+	in := k.compileSchema(v).Unify(v)
+	if err := in.Validate(); err != nil {
+		return in, errors.Wrapf(err, v.Pos(), "invalid %s task %v", k.Name, v.Path())
+	}
+	return in, nil
+}
+
+// TaskFunc 根据节点类型标记创建 runner，没有标记的值不是节点。
+// 直接配合 flow.New 使用时不记录节点状态，when 为 false 只跳过自身，
+// 需要节点状态和级联跳过时使用 NewRun
+func TaskFunc(v cue.Value) (flow.Runner, error) {
+	kind, err := newTask(v)
+	if err != nil || kind == nil {
+		return nil, err
+	}
+
+	return flow.RunnerFunc(func(t *flow.Task) error {
+		in, err := kind.input(t.Value())
+		if err != nil {
+			return err
+		}
+		if ok, err := evalWhen(in); err != nil || !ok {
+			return err
+		}
+		_, err = runTask(t.Context(), t, kind, func(Attempt) {})
+		return err
+	}), nil
+}
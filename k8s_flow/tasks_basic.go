@@ -0,0 +1,165 @@
+package k8s_flow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"cuelang.org/go/cue"
+	"k8s.io/klog/v2"
+)
+
+// HTTPTask 发送一次 http 请求
+var HTTPTask = &TaskKind{
+	Name: "http",
+	Doc: `输入: request.url、request.method(缺省 GET)、request.headers、request.body(字符串或结构体, 结构体按 json 发送);
+expectStatus 期望的状态码, 不一致时节点失败, 429 和 5xx 可以重试
+输出: response.statusCode, response.headers, response.body, 响应是 json 时 response.json`,
+	Schema: `
+request: {
+	url:      string
+	method:   *"GET" | "POST" | "PUT" | "PATCH" | "DELETE" | "HEAD"
+	headers?: [string]: string
+	body?:    _
+}
+expectStatus?: int
+`,
+	Run: func(ctx context.Context, v cue.Value) (interface{}, error) {
+		var request struct {
+			URL     string            `json:"url"`
+			Method  string            `json:"method"`
+			Headers map[string]string `json:"headers"`
+		}
+		if err := v.LookupPath(cue.ParsePath("request")).Decode(&request); err != nil {
+			return nil, err
+		}
+		var body io.Reader
+		if b := v.LookupPath(cue.ParsePath("request.body")); b.Exists() {
+			if s, err := b.String(); err == nil {
+				body = bytes.NewBufferString(s)
+			} else {
+				data, err := b.MarshalJSON()
+				if err != nil {
+					return nil, err
+				}
+				body = bytes.NewBuffer(data)
+				if _, ok := request.Headers["Content-Type"]; !ok {
+					if request.Headers == nil {
+						request.Headers = map[string]string{}
+					}
+					request.Headers["Content-Type"] = "application/json"
+				}
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, request.Method, request.URL, body)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range request.Headers {
+			req.Header.Set(name, value)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		if expect := v.LookupPath(cue.ParsePath("expectStatus")); expect.Exists() {
+			code, err := expect.Int64()
+			if err != nil {
+				return nil, err
+			}
+			if int64(resp.StatusCode) != code {
+				err = fmt.Errorf("%s %s: expect status %d, got %d: %s", request.Method, request.URL, code, resp.StatusCode, respBody)
+				if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+					err = Retryable(err)
+				}
+				return nil, err
+			}
+		}
+
+		headers := map[string]interface{}{}
+		for name := range resp.Header {
+			headers[name] = resp.Header.Get(name)
+		}
+		response := map[string]interface{}{
+			"statusCode": resp.StatusCode,
+			"headers":    headers,
+			"body":       string(respBody),
+		}
+		// 直接交给 cue 解析 json，整数不会被转成 float
+		if json.Valid(respBody) {
+			response["json"] = json.RawMessage(respBody)
+		}
+		return map[string]interface{}{"response": response}, nil
+	},
+}
+
+// SleepTask 等待一段时间
+var SleepTask = &TaskKind{
+	Name:   "sleep",
+	Doc:    `输入: duration 等待时长, 如 10s`,
+	Schema: `duration: string`,
+	Run: func(ctx context.Context, v cue.Value) (interface{}, error) {
+		d, err := lookupDuration(v, "duration")
+		if err != nil {
+			return nil, err
+		}
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, nil
+		}
+	},
+}
+
+// ApproveTask 人工审批，审批通过后才继续执行后续节点
+var ApproveTask = &TaskKind{
+	Name: "approve",
+	Doc: `输入: name 审批名称, 通过所在执行的 Run.Approver().Approve(name, by) 审批; message 审批说明
+输出: approved, approvedBy`,
+	Schema: `
+name:    string
+message: *"" | string
+`,
+	Run: func(ctx context.Context, v cue.Value) (interface{}, error) {
+		name, err := lookupString(v, "name")
+		if err != nil {
+			return nil, err
+		}
+		message, err := lookupString(v, "message")
+		if err != nil {
+			return nil, err
+		}
+
+		klog.Infof("waiting for approval %q: %s", name, message)
+		by, err := approverFrom(ctx).Wait(ctx, name, message)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"approved": true, "approvedBy": by}, nil
+	},
+}
+
+func init() {
+	for _, kind := range []*TaskKind{
+		ApplyTask, KustomizeTask, DeleteTask, GetTask, PatchTask, ScaleTask, WaitTask, ExecJobTask,
+		HTTPTask, SleepTask, ApproveTask, ForEachTask,
+	} {
+		RegisterTaskKind(kind)
+	}
+}
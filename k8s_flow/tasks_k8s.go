@@ -0,0 +1,713 @@
+package k8s_flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"cuelang.org/go/cue"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/penk110/k8s_operator/k8s_client"
+)
+
+// commonSchema 各节点类型共用的定义和引擎控制字段
+const commonSchema = `
+retry?: {
+	attempts: *3 | int & >=1
+	backoff:  *"1s" | string
+	maxDelay: *"30s" | string
+}
+timeout?: string
+when?:    bool
+// when 为 false 跳过节点时，依赖它的节点是否也跳过
+skipDependents: *true | bool
+// 工作流失败时的补偿节点，false 不补偿，未声明使用节点类型的缺省补偿
+compensate?: bool | {...}
+
+#Ref: {
+	apiVersion:     string
+	kind:           string
+	name?:          string
+	namespace?:     string
+	labelSelector?: string
+}
+#Object: {
+	apiVersion: string
+	kind:       string
+	// 定义中的结构体是封闭的，metadata 需要显式允许 namespace、labels 等其他字段
+	metadata: {
+		name: string
+		...
+	}
+	...
+}
+`
+
+// objectRef 节点引用的集群对象
+type objectRef struct {
+	APIVersion    string `json:"apiVersion"`
+	Kind          string `json:"kind"`
+	Name          string `json:"name"`
+	Namespace     string `json:"namespace"`
+	LabelSelector string `json:"labelSelector"`
+}
+
+func (r objectRef) gvk() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(r.APIVersion, r.Kind)
+}
+
+func (r objectRef) String() string {
+	if r.Namespace == "" {
+		return fmt.Sprintf("%s %s", r.Kind, r.Name)
+	}
+	return fmt.Sprintf("%s %s/%s", r.Kind, r.Namespace, r.Name)
+}
+
+// object 只包含定位信息的对象，用于 Delete、WaitReady
+func (r objectRef) object() *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(r.gvk())
+	u.SetName(r.Name)
+	u.SetNamespace(r.Namespace)
+	return u
+}
+
+// resolveRef 优先使用 target，否则从 object 的 metadata 中获取
+func resolveRef(v cue.Value) (objectRef, error) {
+	var ref objectRef
+	if target := v.LookupPath(cue.ParsePath("target")); target.Exists() {
+		err := target.Decode(&ref)
+		return ref, err
+	}
+
+	object := v.LookupPath(cue.ParsePath("object"))
+	if !object.Exists() {
+		return ref, fmt.Errorf("one of target or object is required")
+	}
+	obj, err := decodeObject(object)
+	if err != nil {
+		return ref, err
+	}
+	return objectRef{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Name:       obj.GetName(),
+		Namespace:  obj.GetNamespace(),
+	}, nil
+}
+
+func decodeObject(v cue.Value) (*unstructured.Unstructured, error) {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{}
+	if err = obj.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func lookupString(v cue.Value, path string) (string, error) {
+	return v.LookupPath(cue.ParsePath(path)).String()
+}
+
+func lookupBool(v cue.Value, path string) (bool, error) {
+	return v.LookupPath(cue.ParsePath(path)).Bool()
+}
+
+func lookupDuration(v cue.Value, path string) (time.Duration, error) {
+	s, err := lookupString(v, path)
+	if err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(s)
+}
+
+// liveState 回填到 object 上的线上状态
+func liveState(obj *unstructured.Unstructured) map[string]interface{} {
+	state := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":             string(obj.GetUID()),
+			"resourceVersion": obj.GetResourceVersion(),
+		},
+	}
+	if status, ok := obj.Object["status"]; ok {
+		state["status"] = status
+	}
+	return state
+}
+
+// liveObject 集群中的完整对象，去掉 managedFields 和 last-applied 注解，避免状态过大
+func liveObject(obj *unstructured.Unstructured) map[string]interface{} {
+	obj = obj.DeepCopy()
+	unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration")
+	return obj.Object
+}
+
+// withWarnings 把告警附加到节点输出上
+func withWarnings(out map[string]interface{}, warnings []k8s_client.Warning) map[string]interface{} {
+	if len(warnings) == 0 {
+		return out
+	}
+	texts := make([]string, 0, len(warnings))
+	for _, w := range warnings {
+		texts = append(texts, w.Text)
+	}
+	out["warnings"] = texts
+	return out
+}
+
+// ApplyTask 部署 object，等待就绪后把 uid、resourceVersion、status 回填到 object，
+// 完整的集群对象回填到 live，供 outputs 引用服务端填充的字段
+var ApplyTask = &TaskKind{
+	Name: "apply",
+	Doc: `输入: object 完整的 k8s 对象; wait 是否等待就绪, 缺省 true; waitTimeout 等待超时, 缺省 5m
+输出: object.metadata.uid/resourceVersion, object.status, live 集群中的对象(如 spec.clusterIP), warnings
+补偿: 删除新创建的对象, 或还原为修改前的对象`,
+	Schema: `
+object:      #Object
+wait:        *true | bool
+waitTimeout: *"5m" | string
+`,
+	Run: func(ctx context.Context, v cue.Value) (interface{}, error) {
+		data, err := v.LookupPath(cue.ParsePath("object")).MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		wait, err := lookupBool(v, "wait")
+		if err != nil {
+			return nil, err
+		}
+		timeout, err := lookupDuration(v, "waitTimeout")
+		if err != nil {
+			return nil, err
+		}
+
+		live, warnings, err := applyObject(ctx, data, wait, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return withWarnings(map[string]interface{}{
+			"object": liveState(live),
+			"live":   liveObject(live),
+		}, warnings), nil
+	},
+	Compensate: func(ctx context.Context, data json.RawMessage) error {
+		undo := applyUndo{}
+		if err := json.Unmarshal(data, &undo); err != nil {
+			return err
+		}
+
+		mapper := k8s_client.CachedRestMapper()
+		if undo.Previous != nil {
+			previous, err := json.Marshal(undo.Previous)
+			if err != nil {
+				return err
+			}
+			result, err := k8s_client.Apply(previous, k8s_client.GetConfig(), mapper)
+			if err != nil {
+				return err
+			}
+			RecordObject(ctx, ObjectUpdated, result.Object)
+			return nil
+		}
+
+		created, err := json.Marshal(undo.Created)
+		if err != nil {
+			return err
+		}
+		err = k8s_client.Delete(string(created), k8s_client.GetConfig(), mapper)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		RecordObject(ctx, ObjectDeleted, &unstructured.Unstructured{Object: undo.Created})
+		return nil
+	},
+	Plan: func(ctx context.Context, step *PlanStep, v cue.Value) {
+		planApply(ctx, step, v.LookupPath(cue.ParsePath("object")))
+	},
+}
+
+// applyObject apply data 中的对象并记录撤销信息，wait 时等待就绪，返回集群中的对象，
+// apply 和 kustomize 节点共用
+func applyObject(ctx context.Context, data []byte, wait bool, timeout time.Duration) (*unstructured.Unstructured, []k8s_client.Warning, error) {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		return nil, nil, err
+	}
+	var warnings []k8s_client.Warning
+	if warning, deprecated := k8s_client.CheckDeprecatedAPI(obj.GetAPIVersion(), obj.GetKind()); deprecated {
+		warnings = append(warnings, warning)
+	}
+
+	mapper := k8s_client.CachedRestMapper()
+	previous, err := k8s_client.GetObject(k8s_client.GetConfig(), mapper, obj.GroupVersionKind(), obj.GetNamespace(), obj.GetName())
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, nil, err
+	}
+	undo := applyUndo{}
+	if err == nil {
+		undo.Previous = restorable(previous.Object)
+	}
+
+	result, err := k8s_client.Apply(data, k8s_client.GetConfig(), mapper)
+	if err != nil {
+		return nil, nil, err
+	}
+	warnings = append(warnings, result.Warnings...)
+	if undo.Previous == nil {
+		undo.Created = objectRef{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Name:       result.Object.GetName(),
+			Namespace:  result.Object.GetNamespace(),
+		}.object().Object
+	}
+	if err = RecordUndo(ctx, "apply", undo); err != nil {
+		return nil, nil, err
+	}
+	action := ObjectUpdated
+	if undo.Previous == nil {
+		action = ObjectCreated
+	}
+	RecordObject(ctx, action, result.Object)
+
+	live := result.Object
+	if wait {
+		live, err = k8s_client.WaitReady(ctx, k8s_client.GetConfig(), mapper, live, timeout)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return live, warnings, nil
+}
+
+// applyUndo apply 的撤销信息，Previous 为空时表示对象是新创建的
+type applyUndo struct {
+	Created  map[string]interface{} `json:"created,omitempty"`
+	Previous map[string]interface{} `json:"previous,omitempty"`
+}
+
+// restorable 去掉服务端维护的字段，得到可以重新 apply 的对象
+func restorable(obj *unstructured.Unstructured) map[string]interface{} {
+	obj = obj.DeepCopy()
+	unstructured.RemoveNestedField(obj.Object, "status")
+	for _, field := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "selfLink"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	return obj.Object
+}
+
+// DeleteTask 删除 target 或 object 指向的对象
+var DeleteTask = &TaskKind{
+	Name: "delete",
+	Doc: `输入: target 对象引用或 object 对象; ignoreNotFound 对象不存在时不报错, 缺省 true
+输出: deleted 是否执行了删除`,
+	Schema: `
+target?:        #Ref & {name: string}
+object?:        #Object
+ignoreNotFound: *true | bool
+`,
+	Run: func(ctx context.Context, v cue.Value) (interface{}, error) {
+		ref, err := resolveRef(v)
+		if err != nil {
+			return nil, err
+		}
+		ignoreNotFound, err := lookupBool(v, "ignoreNotFound")
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := ref.object().MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		err = k8s_client.Delete(string(data), k8s_client.GetConfig(), k8s_client.CachedRestMapper())
+		if apierrors.IsNotFound(err) && ignoreNotFound {
+			return map[string]interface{}{"deleted": false}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		RecordObject(ctx, ObjectDeleted, ref.object())
+		return map[string]interface{}{"deleted": true}, nil
+	},
+	Plan: planDelete,
+}
+
+// GetTask 获取单个对象或按 labelSelector 列出对象
+var GetTask = &TaskKind{
+	Name: "get",
+	Doc: `输入: target 对象引用, 有 name 时获取单个对象, 否则按 namespace、labelSelector 列出
+输出: result 单个对象, 或 items 对象列表`,
+	Schema: `
+target: #Ref
+`,
+	Run: func(ctx context.Context, v cue.Value) (interface{}, error) {
+		ref, err := resolveRef(v)
+		if err != nil {
+			return nil, err
+		}
+
+		mapper := k8s_client.CachedRestMapper()
+		if ref.Name != "" {
+			result, err := k8s_client.GetObject(k8s_client.GetConfig(), mapper, ref.gvk(), ref.Namespace, ref.Name)
+			if err != nil {
+				return nil, err
+			}
+			return withWarnings(map[string]interface{}{"result": result.Object.Object}, result.Warnings), nil
+		}
+
+		var (
+			items    []interface{}
+			warnings []k8s_client.Warning
+		)
+		listOptions := k8s_client.ListOptions{
+			Namespace:     ref.Namespace,
+			LabelSelector: ref.LabelSelector,
+			Limit:         500,
+		}
+		err = k8s_client.ListAll(k8s_client.GetConfig(), mapper, ref.gvk(), listOptions, func(page *k8s_client.ListResult) bool {
+			for _, item := range page.Items {
+				items = append(items, item.Object)
+			}
+			warnings = append(warnings, page.Warnings...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if items == nil {
+			items = []interface{}{}
+		}
+		return withWarnings(map[string]interface{}{"items": items}, warnings), nil
+	},
+}
+
+var patchTypes = map[string]types.PatchType{
+	"json":      types.JSONPatchType,
+	"merge":     types.MergePatchType,
+	"strategic": types.StrategicMergePatchType,
+	"apply":     types.ApplyPatchType,
+}
+
+// PatchTask 对已存在的对象执行手写 patch
+var PatchTask = &TaskKind{
+	Name: "patch",
+	Doc: `输入: target 对象引用; patchType json|merge|strategic|apply, 缺省 merge; patch patch 内容;
+resourceVersion 可选的前置条件; force apply 时是否强制接管冲突字段
+输出: result patch 后的对象`,
+	Schema: `
+target:           #Ref & {name: string}
+patchType:        *"merge" | "json" | "strategic" | "apply"
+patch:            _
+resourceVersion?: string
+force:            *false | bool
+`,
+	Run: func(ctx context.Context, v cue.Value) (interface{}, error) {
+		ref, err := resolveRef(v)
+		if err != nil {
+			return nil, err
+		}
+		patchType, err := lookupString(v, "patchType")
+		if err != nil {
+			return nil, err
+		}
+		force, err := lookupBool(v, "force")
+		if err != nil {
+			return nil, err
+		}
+
+		// patch 可以直接写 cue 结构，也可以是 json/yaml 字符串
+		patchValue := v.LookupPath(cue.ParsePath("patch"))
+		var patch []byte
+		if s, err := patchValue.String(); err == nil {
+			patch = []byte(s)
+		} else if patch, err = patchValue.MarshalJSON(); err != nil {
+			return nil, err
+		}
+
+		opts := k8s_client.PatchOptions{
+			PatchType: patchTypes[patchType],
+			Patch:     patch,
+			Force:     force,
+		}
+		if rv := v.LookupPath(cue.ParsePath("resourceVersion")); rv.Exists() {
+			resourceVersion, err := rv.String()
+			if err != nil {
+				return nil, err
+			}
+			opts.ResourceVersion = &resourceVersion
+		}
+
+		result, err := k8s_client.Patch(k8s_client.GetConfig(), k8s_client.CachedRestMapper(), ref.gvk(), ref.Namespace, ref.Name, opts)
+		if err != nil {
+			return nil, err
+		}
+		RecordObject(ctx, ObjectPatched, result.Object)
+		return withWarnings(map[string]interface{}{"result": result.Object.Object}, result.Warnings), nil
+	},
+}
+
+// ScaleTask 修改工作负载副本数
+var ScaleTask = &TaskKind{
+	Name: "scale",
+	Doc: `输入: target 对象引用; replicas 副本数; wait 是否等待就绪, 缺省 true; waitTimeout 缺省 5m
+输出: result 扩缩容后的对象`,
+	Schema: `
+target:      #Ref & {name: string}
+replicas:    int & >=0
+wait:        *true | bool
+waitTimeout: *"5m" | string
+`,
+	Run: func(ctx context.Context, v cue.Value) (interface{}, error) {
+		ref, err := resolveRef(v)
+		if err != nil {
+			return nil, err
+		}
+		replicas, err := v.LookupPath(cue.ParsePath("replicas")).Int64()
+		if err != nil {
+			return nil, err
+		}
+		wait, err := lookupBool(v, "wait")
+		if err != nil {
+			return nil, err
+		}
+		timeout, err := lookupDuration(v, "waitTimeout")
+		if err != nil {
+			return nil, err
+		}
+
+		patch, _ := json.Marshal(map[string]interface{}{
+			"spec": map[string]interface{}{"replicas": replicas},
+		})
+		mapper := k8s_client.CachedRestMapper()
+		result, err := k8s_client.Patch(k8s_client.GetConfig(), mapper, ref.gvk(), ref.Namespace, ref.Name, k8s_client.PatchOptions{
+			PatchType: types.MergePatchType,
+			Patch:     patch,
+		})
+		if err != nil {
+			return nil, err
+		}
+		RecordObject(ctx, ObjectScaled, result.Object)
+
+		live := result.Object
+		if wait {
+			live, err = k8s_client.WaitReady(ctx, k8s_client.GetConfig(), mapper, live, timeout)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return withWarnings(map[string]interface{}{"result": live.Object}, result.Warnings), nil
+	},
+}
+
+// WaitTask 等待对象就绪
+var WaitTask = &TaskKind{
+	Name: "wait",
+	Doc: `输入: target 对象引用; waitTimeout 等待超时, 缺省 5m
+输出: result 就绪后的对象`,
+	Schema: `
+target:      #Ref & {name: string}
+waitTimeout: *"5m" | string
+`,
+	Run: func(ctx context.Context, v cue.Value) (interface{}, error) {
+		ref, err := resolveRef(v)
+		if err != nil {
+			return nil, err
+		}
+		timeout, err := lookupDuration(v, "waitTimeout")
+		if err != nil {
+			return nil, err
+		}
+
+		live, err := k8s_client.WaitReady(ctx, k8s_client.GetConfig(), k8s_client.CachedRestMapper(), ref.object(), timeout)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"result": live.Object}, nil
+	},
+}
+
+// ExecJobTask 以 Job 的方式执行一次命令，等待完成并收集日志。
+// 已经完成的 Job 重新 apply 不会再次运行，同名 Job 存在时先删除（连同 pod）再创建
+var ExecJobTask = &TaskKind{
+	Name: "exec-job",
+	Doc: `输入: job.name、job.namespace(缺省 default)、job.image、job.command、job.args、job.env;
+job.backoffLimit 缺省 0; waitTimeout 等待完成的超时, 缺省 10m; 同名 Job 已存在时先删除再创建, 每次执行都会重新运行
+输出: result.succeeded, result.logs, result.status`,
+	Schema: `
+job: {
+	name:         string
+	namespace:    *"default" | string
+	image:        string
+	command?:     [...string]
+	args?:        [...string]
+	env?:         [string]: string
+	backoffLimit: *0 | int & >=0
+}
+waitTimeout: *"10m" | string
+`,
+	Run: func(ctx context.Context, v cue.Value) (interface{}, error) {
+		var spec struct {
+			Name         string            `json:"name"`
+			Namespace    string            `json:"namespace"`
+			Image        string            `json:"image"`
+			Command      []string          `json:"command"`
+			Args         []string          `json:"args"`
+			Env          map[string]string `json:"env"`
+			BackoffLimit int32             `json:"backoffLimit"`
+		}
+		if err := v.LookupPath(cue.ParsePath("job")).Decode(&spec); err != nil {
+			return nil, err
+		}
+		timeout, err := lookupDuration(v, "waitTimeout")
+		if err != nil {
+			return nil, err
+		}
+
+		container := corev1.Container{
+			Name:    "exec",
+			Image:   spec.Image,
+			Command: spec.Command,
+			Args:    spec.Args,
+		}
+		for name, value := range spec.Env {
+			container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: value})
+		}
+		job := &batchv1.Job{
+			TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      spec.Name,
+				Namespace: spec.Namespace,
+			},
+			Spec: batchv1.JobSpec{
+				BackoffLimit: &spec.BackoffLimit,
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						RestartPolicy: corev1.RestartPolicyNever,
+						Containers:    []corev1.Container{container},
+					},
+				},
+			},
+		}
+		objMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(job)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(objMap)
+		if err != nil {
+			return nil, err
+		}
+
+		previous, err := deleteJob(ctx, spec.Namespace, spec.Name)
+		if err != nil {
+			return nil, err
+		}
+		if previous != nil {
+			RecordObject(ctx, ObjectDeleted, previous)
+		}
+
+		mapper := k8s_client.CachedRestMapper()
+		result, err := k8s_client.Apply(data, k8s_client.GetConfig(), mapper)
+		if err != nil {
+			return nil, err
+		}
+		RecordObject(ctx, ObjectCreated, result.Object)
+
+		live, waitErr := k8s_client.WaitReady(ctx, k8s_client.GetConfig(), mapper, result.Object, timeout)
+		// 等待超时时 ctx 可能已经结束，这时最需要日志
+		logsCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobLogsTimeout)
+		logs := jobLogs(logsCtx, spec.Namespace, spec.Name)
+		cancel()
+		if waitErr != nil {
+			return nil, fmt.Errorf("%v\n%s", waitErr, logs)
+		}
+
+		out := map[string]interface{}{
+			"succeeded": true,
+			"logs":      logs,
+		}
+		if status, ok := live.Object["status"]; ok {
+			out["status"] = status
+		}
+		return withWarnings(map[string]interface{}{"result": out}, result.Warnings), nil
+	},
+}
+
+// jobLogsTimeout 收集 Job 日志的超时，不受节点 ctx 的影响
+const jobLogsTimeout = 30 * time.Second
+
+// deleteJob 删除同名的 Job 并等待它和它的 pod 被回收，返回删除前的对象，不存在时返回 nil
+func deleteJob(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	jobs := k8s_client.GetClientSet().BatchV1().Jobs(namespace)
+	job, err := jobs.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Foreground 删除时 Job 在 pod 都删除后才消失，之后收集日志不会混入旧的 pod
+	propagation := metav1.DeletePropagationForeground
+	err = jobs.Delete(ctx, name, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+		Preconditions:     &metav1.Preconditions{UID: &job.UID},
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	for {
+		_, err = jobs.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait for job %s/%s to be deleted: %v", namespace, name, ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+
+	return objectRef{APIVersion: "batch/v1", Kind: "Job", Namespace: namespace, Name: name}.object(), nil
+}
+
+// jobLogs 收集 job 所有 pod 的日志，失败时只记录错误
+func jobLogs(ctx context.Context, namespace, name string) string {
+	pods, err := k8s_client.GetClientSet().CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "job-name=" + name,
+	})
+	if err != nil {
+		return fmt.Sprintf("list job pods failed: %v", err)
+	}
+
+	var b strings.Builder
+	for _, pod := range pods.Items {
+		raw, err := k8s_client.GetClientSet().CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+		if err != nil {
+			fmt.Fprintf(&b, "get logs of pod %s failed: %v\n", pod.Name, err)
+			continue
+		}
+		b.Write(raw)
+	}
+	return b.String()
+}
@@ -0,0 +1,170 @@
+package k8s_flow
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+
+	"github.com/penk110/k8s_operator/k8s_client"
+)
+
+// KustomizeTask 用 kubectl 内置的 kustomize 渲染 dir 下的 kustomization（如 base/overlay），
+// 按 kustomize 输出的顺序逐个 apply，和 apply 节点走同样的流程。
+// 渲染出的对象以 kind-name 小写为键回填到 objects，后续节点可以引用，如 app.live["service-flowsvc"].spec.clusterIP
+var KustomizeTask = &TaskKind{
+	Name: "kustomize",
+	Doc: `输入: dir kustomization 所在目录, 相对路径相对于 dir 所在的 cue 文件; apply 是否 apply 渲染出的对象, 缺省 true;
+wait 是否等待就绪, 缺省 true; waitTimeout 每个对象的等待超时, 缺省 5m
+输出: objects 渲染出的对象, live 集群中的对象, 都以 kind-name 小写为键, 如 objects["deployment-flowdeploy"]; warnings
+补偿: 同 apply, 倒序撤销每个对象`,
+	Schema: `
+dir:         string
+apply:       *true | bool
+wait:        *true | bool
+waitTimeout: *"5m" | string
+`,
+	Run: func(ctx context.Context, v cue.Value) (interface{}, error) {
+		objs, err := renderKustomization(v)
+		if err != nil {
+			return nil, err
+		}
+		apply, err := lookupBool(v, "apply")
+		if err != nil {
+			return nil, err
+		}
+		wait, err := lookupBool(v, "wait")
+		if err != nil {
+			return nil, err
+		}
+		timeout, err := lookupDuration(v, "waitTimeout")
+		if err != nil {
+			return nil, err
+		}
+
+		objects := map[string]interface{}{}
+		lives := map[string]interface{}{}
+		var warnings []k8s_client.Warning
+		for _, o := range objs {
+			objects[o.key] = o.obj.Object
+			if !apply {
+				continue
+			}
+			data, err := o.obj.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			live, ws, err := applyObject(ctx, data, wait, timeout)
+			warnings = append(warnings, ws...)
+			if err != nil {
+				return nil, fmt.Errorf("apply %s: %v", planResource(o.obj), err)
+			}
+			lives[o.key] = liveObject(live)
+		}
+
+		out := map[string]interface{}{"objects": objects}
+		if apply {
+			out["live"] = lives
+		}
+		return withWarnings(out, warnings), nil
+	},
+	Plan: func(ctx context.Context, step *PlanStep, v cue.Value) {
+		planKustomize(ctx, step, v)
+	},
+}
+
+// kustomizeObject kustomize 渲染出的一个对象，key 为节点输出中的字段名
+type kustomizeObject struct {
+	key string
+	obj *unstructured.Unstructured
+}
+
+// kustomizeDir 节点 dir 字段指向的目录，相对路径相对于 dir 所在的 cue 文件，没有文件位置时相对于当前目录
+func kustomizeDir(v cue.Value) (string, error) {
+	field := v.LookupPath(cue.ParsePath("dir"))
+	dir, err := field.String()
+	if err != nil {
+		return "", err
+	}
+	if filename := field.Pos().Filename(); !filepath.IsAbs(dir) && strings.HasSuffix(filename, ".cue") {
+		dir = filepath.Join(filepath.Dir(filename), dir)
+	}
+	return dir, nil
+}
+
+// renderKustomization 渲染节点的 kustomization，等同于 kubectl kustomize dir
+func renderKustomization(v cue.Value) ([]kustomizeObject, error) {
+	dir, err := kustomizeDir(v)
+	if err != nil {
+		return nil, err
+	}
+	k := krusty.MakeKustomizer(krusty.MakeDefaultOptions())
+	resources, err := k.Run(filesys.MakeFsOnDisk(), dir)
+	if err != nil {
+		return nil, fmt.Errorf("kustomize %s: %v", dir, err)
+	}
+
+	var objs []kustomizeObject
+	used := map[string]int{}
+	for i, r := range resources.Resources() {
+		m, err := r.Map()
+		if err != nil {
+			return nil, fmt.Errorf("kustomize %s: %v", dir, err)
+		}
+		obj := &unstructured.Unstructured{Object: m}
+		objs = append(objs, kustomizeObject{key: objectKey(used, obj.GetKind(), obj.GetName(), i), obj: obj})
+	}
+	if len(objs) == 0 {
+		return nil, fmt.Errorf("kustomize %s: no resources", dir)
+	}
+	return objs, nil
+}
+
+// planKustomize 逐个计算渲染出的对象的变化，字段路径以对象的键开头，全部没有变化时为 no-op，全部新建时为 create
+func planKustomize(ctx context.Context, step *PlanStep, v cue.Value) {
+	objs, err := renderKustomization(v)
+	if err != nil {
+		step.Action = PlanApply
+		step.Error = err.Error()
+		return
+	}
+	if apply, err := lookupBool(v, "apply"); err == nil && !apply {
+		step.Action = PlanRun
+		step.Note = fmt.Sprintf("render %d object(s) only", len(objs))
+		return
+	}
+
+	var resources, errs []string
+	actions := map[PlanAction]int{}
+	for _, o := range objs {
+		sub := &PlanStep{}
+		planApply(ctx, sub, v.Context().Encode(o.obj.Object))
+		resources = append(resources, sub.Resource)
+		actions[sub.Action]++
+		for _, d := range sub.Diffs {
+			d.Path = joinPath(o.key, d.Path)
+			step.Diffs = append(step.Diffs, d)
+		}
+		step.Warnings = append(step.Warnings, sub.Warnings...)
+		if sub.Error != "" {
+			errs = append(errs, o.key+": "+sub.Error)
+		}
+	}
+	step.Resource = strings.Join(resources, ", ")
+	step.Error = strings.Join(errs, "; ")
+	switch {
+	case actions[PlanNoop] == len(objs):
+		step.Action = PlanNoop
+	case actions[PlanCreate] == len(objs):
+		step.Action = PlanCreate
+	case actions[PlanApply] > 0:
+		step.Action = PlanApply
+	default:
+		step.Action = PlanUpdate
+	}
+}
@@ -0,0 +1,123 @@
+package k8s_flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/tools/flow"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/penk110/k8s_operator/k8s_client"
+)
+
+// BuildTeardown 删除工作流 apply 过的对象（包括 kustomize 节点渲染出的对象）的计划，按依赖关系倒序，先删除下游节点的对象。
+// taskFunc 的含义和 BuildPlan 相同，名称运行时才能确定的对象无法删除，标记为 skip
+func BuildTeardown(ctx context.Context, cfg *flow.Config, v cue.Value, taskFunc flow.TaskFunc) (*Plan, error) {
+	if taskFunc == nil {
+		taskFunc = declaredTasks
+	}
+	c := flow.New(cfg, v, taskFunc)
+
+	plan := &Plan{}
+	tasks := sortTasks(c.Tasks())
+	for i := len(tasks) - 1; i >= 0; i-- {
+		t := tasks[i]
+		kind, err := newTask(t.Value())
+		if err != nil {
+			return nil, err
+		}
+		object := t.Value()
+		switch {
+		case kind == nil:
+			// 没有声明类型，整个值就是 apply 的对象
+		case kind.Name == ApplyTask.Name:
+			object = t.Value().LookupPath(cue.ParsePath("object"))
+		case kind.Name == KustomizeTask.Name:
+			plan.Steps = append(plan.Steps, teardownKustomize(ctx, t.Path().String(), t.Value())...)
+			continue
+		default:
+			continue
+		}
+
+		step := PlanStep{Path: t.Path().String(), Kind: ApplyTask.Name}
+		planTeardown(ctx, &step, object)
+		plan.Steps = append(plan.Steps, step)
+	}
+	return plan, nil
+}
+
+// teardownKustomize kustomize 节点渲染出的每个对象一个步骤，按渲染顺序倒序删除
+func teardownKustomize(ctx context.Context, path string, v cue.Value) []PlanStep {
+	objs, err := renderKustomization(v)
+	if err != nil {
+		return []PlanStep{{Path: path, Kind: KustomizeTask.Name, Action: PlanSkip, Error: err.Error()}}
+	}
+	var steps []PlanStep
+	for i := len(objs) - 1; i >= 0; i-- {
+		step := PlanStep{Path: path, Kind: ApplyTask.Name}
+		planTeardown(ctx, &step, v.Context().Encode(objs[i].obj.Object))
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+func planTeardown(ctx context.Context, step *PlanStep, v cue.Value) {
+	var unknown []string
+	local, _ := concreteValue(v, "", &unknown).(map[string]interface{})
+	obj := &unstructured.Unstructured{Object: local}
+	step.Resource = planResource(obj)
+	if obj.GetName() == "" || obj.GetKind() == "" {
+		step.Action = PlanSkip
+		step.Note = "object name is known after apply"
+		return
+	}
+
+	live, err := k8s_client.GetObject(k8s_client.GetConfig(), k8s_client.CachedRestMapper(), obj.GroupVersionKind(), obj.GetNamespace(), obj.GetName())
+	switch {
+	case apierrors.IsNotFound(err):
+		step.Action = PlanNoop
+		step.Note = "object does not exist"
+	case err != nil:
+		// 查询失败时不知道对象是否存在，不能计入删除数量，由用户确认后重试
+		step.Action = PlanSkip
+		step.Note = "object state is unknown"
+		step.Error = err.Error()
+	default:
+		step.Action = PlanDelete
+		step.Resource = planResource(live.Object)
+		step.target = objectRef{
+			APIVersion: live.Object.GetAPIVersion(),
+			Kind:       live.Object.GetKind(),
+			Name:       live.Object.GetName(),
+			Namespace:  live.Object.GetNamespace(),
+		}.object()
+	}
+}
+
+// Teardown 按计划删除对象，遇到错误时停止，已经不存在的对象不算错误
+func Teardown(ctx context.Context, plan *Plan) error {
+	mapper := k8s_client.CachedRestMapper()
+	for _, step := range plan.Steps {
+		if step.Action != PlanDelete {
+			continue
+		}
+		if step.target == nil {
+			return fmt.Errorf("delete %s of %s: object is not resolved", step.Resource, step.Path)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(step.target.Object)
+		if err != nil {
+			return err
+		}
+		err = k8s_client.Delete(string(data), k8s_client.GetConfig(), mapper)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("delete %s of %s: %v", step.Resource, step.Path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package k8s_flow
+
+import (
+	"fmt"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/tools/flow"
+)
+
+// evalWhen 计算节点的 when 条件，没有 when 时返回 true。
+// when 在上游节点完成后计算，可以引用上游节点的输出，如 when: step1.result.changed
+func evalWhen(v cue.Value) (bool, error) {
+	when := v.LookupPath(cue.ParsePath("when"))
+	if !when.Exists() {
+		return true, nil
+	}
+	ok, err := when.Bool()
+	if err != nil {
+		return false, errors.Wrapf(err, when.Pos(), "when must be a concrete bool")
+	}
+	return ok, nil
+}
+
+// skipReason 判断节点是否需要跳过：上游节点被跳过且要求依赖方跳过，或者自身 when 为 false。
+// 返回值 skipDependents 表示依赖当前节点的节点是否也需要跳过
+func (r *Run) skipReason(t *flow.Task, kind *TaskKind) (reason string, skipDependents bool, err error) {
+	r.mu.RLock()
+	for _, dep := range t.Dependencies() {
+		status, ok := r.tasks[dep.Path().String()]
+		if ok && status.Phase == TaskSkipped && status.SkipDependents {
+			r.mu.RUnlock()
+			// 级联跳过，继续向下游传递
+			return fmt.Sprintf("dependency %s was skipped", status.Path), true, nil
+		}
+	}
+	r.mu.RUnlock()
+
+	in, err := kind.input(t.Value())
+	if err != nil {
+		return "", false, err
+	}
+	ok, err := evalWhen(in)
+	if err != nil || ok {
+		return "", false, err
+	}
+	skipDependents, err = lookupBool(in, "skipDependents")
+	if err != nil {
+		return "", false, err
+	}
+	return "when is false", skipDependents, nil
+}
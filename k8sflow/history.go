@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/penk110/k8s_operator/k8s_flow"
+)
+
+// historyCmd 查看执行历史，不带参数时列出执行，带执行 ID 时输出每个节点和修改过的对象
+func historyCmd(args []string) error {
+	o := &options{}
+	fs := newFlagSet("history", o)
+	addHistoryFlags(fs, o, defaultHistoryDir())
+	workflow := fs.String("workflow", "", "only list runs of this workflow")
+	user := fs.String("user", "", "only list runs started by this user")
+	phase := fs.String("phase", "", "only list runs in this phase, e.g. Failed")
+	object := fs.String("object", "", "only list runs that changed this object, kind/name or kind/namespace/name")
+	since := fs.String("since", "", "only list runs started after this time, RFC3339, 2006-01-02 or a duration like 24h")
+	until := fs.String("until", "", "only list runs started before this time, same format as --since")
+	limit := fs.Int("limit", 50, "list at most this many runs, 0 means no limit")
+	if err := parse(fs, o, args, -1); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("expected at most 1 argument, got %d", fs.NArg())
+	}
+	store, err := o.historyStore()
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return fmt.Errorf("run history is disabled")
+	}
+
+	ctx := context.Background()
+	if fs.NArg() == 1 {
+		rec, err := store.Get(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			return fmt.Errorf("run record %s not found in %s", fs.Arg(0), store.Dir)
+		}
+		return o.print(os.Stdout, rec, func(w io.Writer) error {
+			return printRecord(w, rec)
+		})
+	}
+
+	filter := k8s_flow.HistoryFilter{
+		Workflow: *workflow,
+		User:     *user,
+		Phase:    k8s_flow.TaskPhase(*phase),
+		Object:   *object,
+		Limit:    *limit,
+	}
+	now := time.Now()
+	if *since != "" {
+		if filter.Since, err = k8s_flow.ParseHistoryTime(*since, now); err != nil {
+			return err
+		}
+	}
+	if *until != "" {
+		if filter.Until, err = k8s_flow.ParseHistoryTime(*until, now); err != nil {
+			return err
+		}
+	}
+	records, err := store.List(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return o.print(os.Stdout, records, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tWORKFLOW\tUSER\tTRIGGER\tPHASE\tSTARTED\tDURATION\tOBJECTS")
+		for _, rec := range records {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\n", rec.ID, rec.Workflow, rec.User, rec.Trigger, rec.Phase,
+				rec.StartedAt.Local().Format("2006-01-02 15:04:05"), duration(rec.StartedAt, rec.FinishedAt), len(rec.Objects()))
+		}
+		return tw.Flush()
+	})
+}
+
+// printRecord 输出单次执行的审计记录
+func printRecord(w io.Writer, rec *k8s_flow.RunRecord) error {
+	fmt.Fprintf(w, "Run:      %s\n", rec.ID)
+	if rec.RunID != "" && rec.RunID != rec.ID {
+		fmt.Fprintf(w, "Run ID:   %s\n", rec.RunID)
+	}
+	fmt.Fprintf(w, "Workflow: %s\nUser:     %s\nTrigger:  %s\nPhase:    %s\n", rec.Workflow, rec.User, rec.Trigger, rec.Phase)
+	fmt.Fprintf(w, "Started:  %s\n", rec.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	if !rec.FinishedAt.IsZero() {
+		fmt.Fprintf(w, "Finished: %s (%s)\n", rec.FinishedAt.Local().Format("2006-01-02 15:04:05"), duration(rec.StartedAt, rec.FinishedAt))
+	}
+	fmt.Fprintf(w, "Input:    %s\n", rec.InputHash)
+	if rec.ParamsHash != "" {
+		fmt.Fprintf(w, "Params:   %s\n", rec.ParamsHash)
+	}
+	if rec.Error != "" {
+		fmt.Fprintf(w, "Error:    %s\n", rec.Error)
+	}
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TASK\tKIND\tPHASE\tATTEMPTS\tDURATION\tMESSAGE")
+	for _, t := range rec.Tasks {
+		message := t.Error
+		if message == "" {
+			message = t.SkipReason
+		}
+		if t.Resumed {
+			message = strings.TrimSpace("resumed " + message)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", t.Path, t.Kind, t.Phase, len(t.Attempts), duration(t.StartedAt, t.FinishedAt), message)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(rec.Objects()) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nObjects:")
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TASK\tACTION\tOBJECT\tRESOURCE VERSION\tTIME")
+	for _, t := range rec.Tasks {
+		for _, c := range t.Objects {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.Path, c.Action, c, c.ResourceVersion, c.Time.Local().Format("2006-01-02 15:04:05"))
+		}
+	}
+	return tw.Flush()
+}
+
+// duration 开始和结束之间的时长，没有结束时为 -
+func duration(start, end time.Time) string {
+	if start.IsZero() || end.IsZero() {
+		return "-"
+	}
+	return end.Sub(start).Round(time.Millisecond).String()
+}
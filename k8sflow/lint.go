@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/penk110/k8s_operator/k8s_client"
+	"github.com/penk110/k8s_operator/k8s_flow"
+)
+
+// lintCmd 静态检查工作流并按 --schema 校验渲染出的对象，有 error 级别的问题时返回错误
+func lintCmd(args []string) error {
+	o := &options{}
+	fs := newFlagSet("lint", o)
+	addSchemaFlag(fs, o)
+	if err := parse(fs, o, args, 1); err != nil {
+		return err
+	}
+	if o.schema == k8s_client.SchemaCluster {
+		if err := o.initKube(); err != nil {
+			return err
+		}
+	}
+	v, err := o.load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	issues, err := o.lint(v)
+	if err != nil {
+		return err
+	}
+	if issues == nil {
+		issues = []k8s_flow.LintIssue{}
+	}
+	err = o.print(os.Stdout, issues, func(w io.Writer) error {
+		for _, issue := range issues {
+			fmt.Fprintln(w, issue)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	errs := 0
+	for _, issue := range issues {
+		if issue.Level == k8s_flow.LintError {
+			errs++
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("%d error(s) found", errs)
+	}
+	return nil
+}
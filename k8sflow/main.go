@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"k8s.io/klog/v2"
+)
+
+// k8sflow 工作流命令行，代替原来的 cue_flow、cur_flow2、cur_flow3、deployment_1/handler_test 几个单独的 main：
+//
+//	k8sflow run      [flags] PATH      执行工作流，PATH 是 .cue 文件或 cue 包所在的目录
+//	k8sflow plan     [flags] PATH      只输出执行计划，不修改集群
+//	k8sflow apply    [flags] PATH      输出执行计划，确认后执行
+//	k8sflow delete   [flags] PATH      按依赖关系倒序删除工作流 apply 的对象
+//	k8sflow status   [flags] RUN_ID    查看保存的执行状态
+//	k8sflow history  [flags] [ID]      查看执行历史，谁在什么时候执行了哪个工作流、修改了哪些对象
+//	k8sflow graph    [flags] PATH      输出节点依赖图
+//	k8sflow lint     [flags] PATH      静态检查工作流，run、apply 执行前也会检查
+//	k8sflow serve    [flags] FILE...   启动页面和 REST API，每个文件是一个模板
+//	k8sflow operator [flags]           运行 Workflow CRD 控制器
+//
+// 所有子命令共用 --kubeconfig、--context、-n、-p、--inputs、-o 等参数，见 k8sflow <command> -h
+//
+// 例如按 deployment_1/handler 的方式部署 deployment_1 中的清单：
+//
+//	k8sflow plan --objects --root workflow deployment_1/flow_templates/deploy_flow.cue
+//	k8sflow run  --objects --root workflow deployment_1/flow_templates/deploy_flow.cue
+
+type command struct {
+	name  string
+	usage string
+	short string
+	run   func(args []string) error
+}
+
+var commands = []*command{
+	{name: "run", usage: "run [flags] PATH", short: "run a workflow file or cue package", run: runCmd},
+	{name: "plan", usage: "plan [flags] PATH", short: "show what a workflow would change without applying it", run: planCmd},
+	{name: "apply", usage: "apply [flags] PATH", short: "show the plan and run the workflow after confirmation", run: applyCmd},
+	{name: "delete", usage: "delete [flags] PATH", short: "delete the objects applied by a workflow in reverse dependency order", run: deleteCmd},
+	{name: "status", usage: "status [flags] RUN_ID", short: "show the saved state of a run", run: statusCmd},
+	{name: "history", usage: "history [flags] [ID]", short: "list past runs, or show the tasks and changed objects of one run", run: historyCmd},
+	{name: "graph", usage: "graph [flags] PATH", short: "print the task dependency graph", run: graphCmd},
+	{name: "lint", usage: "lint [flags] PATH", short: "statically check a workflow without running it", run: lintCmd},
+	{name: "serve", usage: "serve [flags] FILE...", short: "serve the web UI and REST API with the given workflow templates", run: serveCmd},
+	{name: "operator", usage: "operator [flags]", short: "run the Workflow CRD controller", run: operatorCmd},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: k8sflow <command> [flags] [args]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", c.name, c.short)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'k8sflow <command> -h' for the flags of a command.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "-h" || name == "--help" || name == "help" {
+		usage()
+		return
+	}
+
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		err := c.run(os.Args[2:])
+		if err == flag.ErrHelp {
+			return
+		}
+		if err != nil {
+			klog.Flush()
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/load"
+	"cuelang.org/go/tools/flow"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"github.com/penk110/k8s_operator/deployment_1/handler"
+	"github.com/penk110/k8s_operator/k8s_client"
+	"github.com/penk110/k8s_operator/k8s_flow"
+)
+
+// options 各子命令共用的参数
+type options struct {
+	kubeconfig  string
+	kubeContext string
+	namespace   string
+	inputsFile  string
+	inputs      k8s_flow.Inputs
+	output      string
+	root        string
+	// objects 根节点下没有声明 $task 的字段当作要 apply 的对象，即 deployment_1/handler 的写法
+	objects bool
+	// schema 校验对象用的 OpenAPI schema，见 addSchemaFlag
+	schema string
+	// params load 时合并后的参数，写入执行历史
+	params k8s_flow.Inputs
+	// history 执行历史的目录和保留策略，见 addHistoryFlags
+	history        string
+	historyMaxAge  time.Duration
+	historyMaxRuns int
+}
+
+func newFlagSet(name string, o *options) *flag.FlagSet {
+	fs := flag.NewFlagSet("k8sflow "+name, flag.ContinueOnError)
+	o.inputs = k8s_flow.Inputs{}
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "path to the kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config")
+	fs.StringVar(&o.kubeContext, "context", "", "kubeconfig context to use, defaults to the current context")
+	fs.StringVar(&o.namespace, "n", "", "namespace of objects without one, defaults to the namespace of the context")
+	fs.StringVar(&o.inputsFile, "inputs", "", "JSON or YAML file with workflow inputs")
+	fs.Var(o.inputs, "p", "workflow input key=value, can be repeated and overrides --inputs")
+	fs.StringVar(&o.output, "o", "text", "output format: text, json or yaml")
+	fs.StringVar(&o.root, "root", "", "only look for tasks under this path, e.g. workflow")
+	fs.BoolVar(&o.objects, "objects", false, "treat fields under --root without $task as objects to apply")
+	return fs
+}
+
+// addSchemaFlag lint、run、apply 执行前用 OpenAPI schema 校验渲染出的对象
+func addSchemaFlag(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.schema, "schema", k8s_client.DefaultSchemaVersion, fmt.Sprintf(
+		"validate objects against this OpenAPI schema: %s, a bundled version (%s), a swagger.json file, or none",
+		k8s_client.SchemaCluster, strings.Join(k8s_client.SchemaVersions(), ", ")))
+}
+
+// addHistoryFlags 执行历史的目录，def 为 none 时缺省不记录
+func addHistoryFlags(fs *flag.FlagSet, o *options, def string) {
+	fs.StringVar(&o.history, "history", def, "directory of the run history, none disables it")
+	fs.DurationVar(&o.historyMaxAge, "history-max-age", k8s_flow.DefaultHistoryMaxAge, "delete run records older than this, 0 keeps them")
+	fs.IntVar(&o.historyMaxRuns, "history-max-runs", k8s_flow.DefaultHistoryMaxRuns, "keep at most this many run records, 0 means no limit")
+}
+
+// defaultHistoryDir ~/.k8sflow/history，没有 home 目录时不记录
+func defaultHistoryDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "none"
+	}
+	return filepath.Join(home, ".k8sflow", "history")
+}
+
+// historyStore --history 对应的执行历史，none 时返回 nil
+func (o *options) historyStore() (*k8s_flow.FileHistory, error) {
+	if o.history == "" || o.history == "none" {
+		return nil, nil
+	}
+	store, err := k8s_flow.NewFileHistory(o.history)
+	if err != nil {
+		return nil, err
+	}
+	store.MaxAge, store.MaxRuns = o.historyMaxAge, o.historyMaxRuns
+	return store, nil
+}
+
+// parse 解析参数，要求恰好 n 个位置参数，n < 0 时不限制
+func parse(fs *flag.FlagSet, o *options, args []string, n int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if n >= 0 && fs.NArg() != n {
+		fs.Usage()
+		return fmt.Errorf("expected %d argument(s), got %d", n, fs.NArg())
+	}
+	switch o.output {
+	case "text", "json", "yaml":
+	default:
+		if fs.Name() != "k8sflow graph" {
+			return fmt.Errorf("unknown output format %q", o.output)
+		}
+	}
+	if o.objects && o.root == "" {
+		return fmt.Errorf("--objects requires --root")
+	}
+	return nil
+}
+
+// initKube 按 --kubeconfig、--context 初始化 k8s_client，找不到 kubeconfig 时使用 k8s_client 内置的配置
+func (o *options) initKube() error {
+	cfg, namespace, err := k8s_client.LoadKubeconfig(o.kubeconfig, o.kubeContext)
+	switch {
+	case err == nil:
+		if err = k8s_client.Init(cfg); err != nil {
+			return err
+		}
+	case clientcmd.IsEmptyConfig(err) && o.kubeconfig == "" && o.kubeContext == "":
+		klog.V(2).Infof("no kubeconfig found, using the built-in cluster config")
+	default:
+		return fmt.Errorf("load kubeconfig: %v", err)
+	}
+
+	if o.namespace != "" {
+		namespace = o.namespace
+	}
+	if namespace != "" {
+		k8s_client.DefaultNamespace = namespace
+	}
+	return nil
+}
+
+// load 加载工作流并合并参数，path 可以是单个 .cue 文件或 cue 包所在的目录，
+// 会向上查找 cue.mod，所以可以引用模块内的其他包，@manifest 引用的 YAML 清单也在这里加载
+func (o *options) load(path string) (cue.Value, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return cue.Value{}, err
+	}
+	dir, args := path, []string{"."}
+	if !info.IsDir() {
+		dir, args = filepath.Dir(path), []string{filepath.Base(path)}
+	}
+
+	insts := load.Instances(args, &load.Config{Dir: dir})
+	if len(insts) == 0 {
+		return cue.Value{}, fmt.Errorf("no cue instance found in %s", path)
+	}
+	if insts[0].Err != nil {
+		return cue.Value{}, insts[0].Err
+	}
+	v := cuecontext.New().BuildInstance(insts[0])
+	if v.Err() != nil {
+		return v, v.Err()
+	}
+	if v, err = k8s_flow.LoadManifests(v); err != nil {
+		return v, err
+	}
+
+	inputs := k8s_flow.Inputs{}
+	if o.inputsFile != "" {
+		if inputs, err = k8s_flow.LoadInputsFile(o.inputsFile); err != nil {
+			return v, err
+		}
+	}
+	inputs.Merge(o.inputs)
+	o.params = k8s_flow.ResolveInputs(v, inputs)
+	return k8s_flow.ApplyInputs(v, inputs)
+}
+
+func (o *options) flowConfig() *flow.Config {
+	cfg := &flow.Config{}
+	if o.root != "" {
+		cfg.Root = cue.ParsePath(o.root)
+	}
+	return cfg
+}
+
+// lint 静态检查工作流，并按 --schema 校验渲染出的对象
+func (o *options) lint(v cue.Value) ([]k8s_flow.LintIssue, error) {
+	issues := k8s_flow.Lint(o.flowConfig(), v, o.taskFunc())
+	if o.schema == "" || o.schema == "none" {
+		return issues, nil
+	}
+	resources, err := k8s_client.LoadSchemas(o.schema)
+	if err != nil {
+		return issues, err
+	}
+	return append(issues, k8s_flow.ValidateSchemas(o.flowConfig(), v, o.taskFunc(), resources)...), nil
+}
+
+// taskFunc plan、delete 识别节点的方式，为空时按 $task 识别
+func (o *options) taskFunc() flow.TaskFunc {
+	if o.objects {
+		return handler.Handler
+	}
+	return nil
+}
+
+// print 按 -o 输出 v，text 时调用 text
+func (o *options) print(w io.Writer, v interface{}, text func(w io.Writer) error) error {
+	switch o.output {
+	case "json":
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "yaml":
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	return text(w)
+}
+
+// confirm 提示用户输入 yes 确认
+func confirm(prompt string) bool {
+	fmt.Fprintf(os.Stderr, "%s\n  Only 'yes' will be accepted to approve.\n\n  Enter a value: ", prompt)
+	var answer string
+	_, _ = fmt.Fscanln(os.Stdin, &answer)
+	return strings.TrimSpace(answer) == "yes"
+}
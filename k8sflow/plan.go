@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/penk110/k8s_operator/k8s_flow"
+)
+
+func planCmd(args []string) error {
+	o := &options{}
+	fs := newFlagSet("plan", o)
+	if err := parse(fs, o, args, 1); err != nil {
+		return err
+	}
+	if err := o.initKube(); err != nil {
+		return err
+	}
+	v, err := o.load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	plan, err := k8s_flow.BuildPlan(context.Background(), o.flowConfig(), v, o.taskFunc())
+	if err != nil {
+		return err
+	}
+	return o.print(os.Stdout, plan, plan.Render)
+}
+
+func deleteCmd(args []string) error {
+	o := &options{}
+	fs := newFlagSet("delete", o)
+	yes := fs.Bool("yes", false, "skip the confirmation")
+	dryRun := fs.Bool("dry-run", false, "only print what would be deleted")
+	if err := parse(fs, o, args, 1); err != nil {
+		return err
+	}
+	if err := o.initKube(); err != nil {
+		return err
+	}
+	v, err := o.load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	plan, err := k8s_flow.BuildTeardown(ctx, o.flowConfig(), v, o.taskFunc())
+	if err != nil {
+		return err
+	}
+	if err = o.print(os.Stdout, plan, plan.Render); err != nil {
+		return err
+	}
+	if _, _, destroy, _ := plan.Summary(); destroy == 0 || *dryRun {
+		return nil
+	}
+	if !*yes && !confirm("\nDo you really want to delete these objects?") {
+		return fmt.Errorf("delete cancelled")
+	}
+	return k8s_flow.Teardown(ctx, plan)
+}
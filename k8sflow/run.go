@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/tools/flow"
+
+	"github.com/penk110/k8s_operator/deployment_1/handler"
+	"github.com/penk110/k8s_operator/k8s_client"
+	"github.com/penk110/k8s_operator/k8s_flow"
+)
+
+// runOptions run、apply 的参数
+type runOptions struct {
+	options
+	id               string
+	stateDir         string
+	compensation     string
+	outputsFile      string
+	outputsConfigMap string
+	yes              bool
+	timeout          time.Duration
+	gracePeriod      time.Duration
+	user             string
+	// workflow 执行历史中的工作流名，去掉扩展名的文件名或目录名
+	workflow string
+}
+
+func newRunFlagSet(name string, o *runOptions) *flag.FlagSet {
+	fs := newFlagSet(name, &o.options)
+	fs.StringVar(&o.id, "id", "", "run ID, required to resume a run saved with --state")
+	fs.StringVar(&o.stateDir, "state", "", "directory to save the run state in, the run is resumed if the state exists")
+	fs.StringVar(&o.compensation, "compensation", string(k8s_flow.CompensateNever), "compensation mode when the workflow fails")
+	fs.StringVar(&o.outputsFile, "outputs-file", "", "also write the workflow outputs to this JSON or YAML file")
+	fs.StringVar(&o.outputsConfigMap, "outputs-configmap", "", "also write the workflow outputs to this ConfigMap")
+	fs.BoolVar(&o.yes, "yes", false, "skip the confirmation of apply")
+	fs.DurationVar(&o.timeout, "timeout", 0, "fail the workflow if it takes longer than this, 0 means no timeout")
+	fs.DurationVar(&o.gracePeriod, "grace-period", k8s_flow.DefaultGracePeriod, "how long to wait for running tasks to stop after the run is cancelled")
+	fs.StringVar(&o.user, "user", currentUser(), "user recorded in the run history")
+	addSchemaFlag(fs, &o.options)
+	addHistoryFlags(fs, &o.options, defaultHistoryDir())
+	return fs
+}
+
+// currentUser 当前系统用户，获取不到时为 unknown
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return "unknown"
+}
+
+func runCmd(args []string) error {
+	o := &runOptions{}
+	fs := newRunFlagSet("run", o)
+	if err := parse(fs, &o.options, args, 1); err != nil {
+		return err
+	}
+	return o.run(fs.Arg(0), false)
+}
+
+func applyCmd(args []string) error {
+	o := &runOptions{}
+	fs := newRunFlagSet("apply", o)
+	if err := parse(fs, &o.options, args, 1); err != nil {
+		return err
+	}
+	return o.run(fs.Arg(0), true)
+}
+
+// run 执行工作流，节点事件输出到标准错误，最终状态和 outputs 输出到标准输出
+func (o *runOptions) run(path string, withPlan bool) error {
+	if err := o.initKube(); err != nil {
+		return err
+	}
+	v, err := o.load(path)
+	if err != nil {
+		return err
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	o.workflow = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	issues, err := o.lint(v)
+	if err != nil {
+		return err
+	}
+	for _, issue := range issues {
+		if issue.Level == k8s_flow.LintWarning {
+			fmt.Fprintln(os.Stderr, issue)
+		}
+	}
+	if err = k8s_flow.LintErr(issues); err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		// 第一次信号取消执行，等待节点退出并保存状态，恢复缺省处理后再次 Ctrl-C 直接退出
+		<-ctx.Done()
+		cancel()
+	}()
+
+	if withPlan {
+		plan, err := k8s_flow.BuildPlan(ctx, o.flowConfig(), v, o.taskFunc())
+		if err != nil {
+			return err
+		}
+		if err = plan.Render(os.Stderr); err != nil {
+			return err
+		}
+		if !o.yes && !confirm("\nDo you want to run this workflow?") {
+			return fmt.Errorf("apply cancelled")
+		}
+	}
+
+	if o.objects {
+		return o.runObjects(ctx, v)
+	}
+
+	opts, err := o.runOpts()
+	if err != nil {
+		return err
+	}
+	run := k8s_flow.NewRun(o.flowConfig(), v, opts...)
+	_, ch, unsubscribe := run.Events().Subscribe(0)
+	defer unsubscribe()
+
+	done := make(chan error, 1)
+	go func() { done <- run.Run(ctx) }()
+	for e := range ch {
+		printEvent(os.Stderr, e)
+	}
+	runErr := <-done
+
+	result := struct {
+		ID      string                `json:"id,omitempty"`
+		Record  string                `json:"record,omitempty"`
+		Tasks   []k8s_flow.TaskStatus `json:"tasks"`
+		Outputs json.RawMessage       `json:"outputs,omitempty"`
+		Error   string                `json:"error,omitempty"`
+	}{ID: run.ID(), Record: run.RecordID(), Tasks: run.Tasks(), Outputs: run.Outputs()}
+	if runErr != nil {
+		result.Error = runErr.Error()
+	}
+	if result.Record != "" {
+		fmt.Fprintf(os.Stderr, "run recorded as %s, see k8sflow history %s\n", result.Record, result.Record)
+	}
+	err = o.print(os.Stdout, result, func(w io.Writer) error {
+		if err := printTasks(w, result.Tasks); err != nil {
+			return err
+		}
+		if len(result.Outputs) == 0 {
+			return nil
+		}
+		data, err := k8s_flow.FormatOutputs(result.Outputs, "yaml")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "\nOutputs:\n%s", data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return runErr
+}
+
+func (o *runOptions) runOpts() ([]k8s_flow.RunOption, error) {
+	mode, err := k8s_flow.ParseCompensationMode(o.compensation)
+	if err != nil {
+		return nil, err
+	}
+	opts := []k8s_flow.RunOption{
+		k8s_flow.WithCompensation(mode),
+		k8s_flow.WithGracePeriod(o.gracePeriod),
+	}
+	if o.timeout > 0 {
+		opts = append(opts, k8s_flow.WithTimeout(o.timeout))
+	}
+	if o.stateDir != "" {
+		if o.id == "" {
+			return nil, fmt.Errorf("--state requires --id")
+		}
+		store, err := k8s_flow.NewFileStore(o.stateDir)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, k8s_flow.WithStateStore(store))
+	}
+	if o.id != "" {
+		opts = append(opts, k8s_flow.WithID(o.id))
+	}
+	history, err := o.historyStore()
+	if err != nil {
+		return nil, err
+	}
+	if history != nil {
+		opts = append(opts, k8s_flow.WithHistory(history, k8s_flow.RunMeta{
+			Workflow: o.workflow,
+			User:     o.user,
+			Trigger:  "cli",
+			Params:   o.params,
+		}))
+	}
+
+	var exporters []k8s_flow.OutputExporter
+	if o.outputsFile != "" {
+		exporters = append(exporters, &k8s_flow.FileExporter{Path: o.outputsFile})
+	}
+	if o.outputsConfigMap != "" {
+		exporters = append(exporters, &k8s_flow.ConfigMapExporter{
+			Client:    k8s_client.GetClientSet(),
+			Namespace: k8s_client.DefaultNamespace,
+			Name:      o.outputsConfigMap,
+		})
+	}
+	if len(exporters) > 0 {
+		opts = append(opts, k8s_flow.WithOutputExporters(exporters...))
+	}
+	return opts, nil
+}
+
+// runObjects 按 deployment_1/handler 的方式执行，每个节点就是要 apply 的对象
+func (o *runOptions) runObjects(ctx context.Context, v cue.Value) error {
+	cfg := o.flowConfig()
+	events := k8s_flow.WatchFlow(cfg)
+	c := flow.New(cfg, v, o.taskFunc())
+
+	_, ch, unsubscribe := events.Subscribe(0)
+	defer unsubscribe()
+	done := make(chan error, 1)
+	go func() {
+		defer events.CloseFlow(c)
+		// 告警只属于这次执行
+		done <- c.Run(handler.WithTaskWarnings(ctx, handler.NewTaskWarnings()))
+	}()
+	for e := range ch {
+		printEvent(os.Stderr, e)
+	}
+	if err := <-done; err != nil {
+		return err
+	}
+	// flow 被取消时不返回错误
+	if ctx.Err() != nil {
+		return k8s_flow.ErrCancelled
+	}
+	return nil
+}
+
+func printEvent(w io.Writer, e k8s_flow.Event) {
+	if e.Type != k8s_flow.EventTask {
+		fmt.Fprintf(w, "%s run %s\n", e.Time.Format(time.RFC3339), e.State)
+		return
+	}
+	state := e.State
+	if e.Phase != "" {
+		state = string(e.Phase)
+	}
+	if e.Error != "" {
+		fmt.Fprintf(w, "%s %-24s %-10s %s\n", e.Time.Format(time.RFC3339), e.Path, state, e.Error)
+		return
+	}
+	fmt.Fprintf(w, "%s %-24s %s\n", e.Time.Format(time.RFC3339), e.Path, state)
+}
+
+func printTasks(w io.Writer, tasks []k8s_flow.TaskStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TASK\tKIND\tPHASE\tATTEMPTS\tDURATION\tMESSAGE")
+	for _, t := range tasks {
+		duration := "-"
+		if !t.StartedAt.IsZero() && !t.FinishedAt.IsZero() {
+			duration = t.FinishedAt.Sub(t.StartedAt).Round(time.Millisecond).String()
+		}
+		message := t.Error
+		if message == "" {
+			message = t.SkipReason
+		}
+		if t.Resumed {
+			message = "resumed " + message
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", t.Path, t.Kind, t.Phase, len(t.Attempts), duration, message)
+	}
+	return tw.Flush()
+}
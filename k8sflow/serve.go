@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/penk110/k8s_operator/k8s_flow"
+	"github.com/penk110/k8s_operator/k8s_flow/controller"
+	"github.com/penk110/k8s_operator/k8s_flow/server"
+)
+
+// serveCmd 启动页面和 REST API，每个文件注册为一个模板，模板名为去掉扩展名的文件名
+func serveCmd(args []string) error {
+	o := &options{}
+	fs := newFlagSet("serve", o)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	stateDir := fs.String("state", "", "directory to save run states in")
+	gracePeriod := fs.Duration("grace-period", k8s_flow.DefaultGracePeriod, "how long to wait for running tasks to stop when a run is cancelled or the server shuts down")
+	addHistoryFlags(fs, o, defaultHistoryDir())
+	if err := parse(fs, o, args, -1); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("at least one workflow file is required")
+	}
+	if err := o.initKube(); err != nil {
+		return err
+	}
+
+	registry := server.NewRegistry()
+	registry.Options = append(registry.Options, k8s_flow.WithGracePeriod(*gracePeriod))
+	if *stateDir != "" {
+		store, err := k8s_flow.NewFileStore(*stateDir)
+		if err != nil {
+			return err
+		}
+		registry.State = store
+	}
+	history, err := o.historyStore()
+	if err != nil {
+		return err
+	}
+	if history != nil {
+		registry.History = history
+	}
+	for _, path := range fs.Args() {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if err = registry.AddTemplate(server.Template{Name: name, Source: string(source), Root: o.root}); err != nil {
+			return err
+		}
+	}
+
+	r := gin.Default()
+	server.RegisterUI(r, registry)
+	server.Register(r, registry)
+
+	srv := &http.Server{Addr: *addr, Handler: r}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		registry.Shutdown()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	klog.Infof("k8sflow serving on %s", *addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// operatorCmd 运行 Workflow CRD 控制器，先 kubectl apply -f k8s_flow/controller/crd.yaml 安装 CRD
+func operatorCmd(args []string) error {
+	o := &options{}
+	fs := newFlagSet("operator", o)
+	workers := fs.Int("workers", 2, "number of concurrent reconcile workers")
+	gracePeriod := fs.Duration("grace-period", k8s_flow.DefaultGracePeriod, "how long to wait for running tasks to stop on shutdown, keep it below the pod's terminationGracePeriodSeconds")
+	// 容器中的目录通常不是持久的，缺省不记录
+	addHistoryFlags(fs, o, "none")
+	if err := parse(fs, o, args, 0); err != nil {
+		return err
+	}
+	if err := o.initKube(); err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	// 不指定 -n 时监听所有命名空间
+	c := controller.New(o.namespace)
+	c.GracePeriod = *gracePeriod
+	history, err := o.historyStore()
+	if err != nil {
+		return err
+	}
+	if history != nil {
+		c.History = history
+	}
+	return c.Run(ctx, *workers)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"cuelang.org/go/tools/flow"
+
+	"github.com/penk110/k8s_operator/k8s_client"
+	"github.com/penk110/k8s_operator/k8s_flow"
+)
+
+// statusCmd 查看保存的执行状态，--state 指定时从本地目录读取，否则从集群中的 ConfigMap 读取
+func statusCmd(args []string) error {
+	o := &options{}
+	fs := newFlagSet("status", o)
+	stateDir := fs.String("state", "", "directory the run state was saved in, read from ConfigMaps in the namespace if empty")
+	if err := parse(fs, o, args, 1); err != nil {
+		return err
+	}
+
+	var store k8s_flow.StateStore
+	if *stateDir != "" {
+		fileStore, err := k8s_flow.NewFileStore(*stateDir)
+		if err != nil {
+			return err
+		}
+		store = fileStore
+	} else {
+		if err := o.initKube(); err != nil {
+			return err
+		}
+		store = k8s_flow.NewConfigMapStore(k8s_client.GetClientSet(), k8s_client.DefaultNamespace)
+	}
+
+	state, err := store.Load(context.Background(), fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("run %s not found", fs.Arg(0))
+	}
+	return o.print(os.Stdout, state, func(w io.Writer) error {
+		fmt.Fprintf(w, "Run: %s\nUpdated: %s\n\n", state.ID, state.UpdatedAt.Format("2006-01-02 15:04:05"))
+		if err := printTasks(w, state.Tasks); err != nil {
+			return err
+		}
+		if len(state.Outputs) == 0 {
+			return nil
+		}
+		data, err := k8s_flow.FormatOutputs(state.Outputs, "yaml")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "\nOutputs:\n%s", data)
+		return err
+	})
+}
+
+// graphCmd 输出节点依赖图，-o 为 dot、mermaid、json 或 yaml，text 等同于 dot
+func graphCmd(args []string) error {
+	o := &options{}
+	fs := newFlagSet("graph", o)
+	if err := parse(fs, o, args, 1); err != nil {
+		return err
+	}
+	v, err := o.load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var g *k8s_flow.Graph
+	if o.objects {
+		g = k8s_flow.GraphOf(flow.New(o.flowConfig(), v, o.taskFunc()))
+	} else {
+		g = k8s_flow.NewRun(o.flowConfig(), v).Graph()
+	}
+
+	switch o.output {
+	case "text", "dot":
+		fmt.Print(g.DOT())
+	case "mermaid":
+		fmt.Print(g.Mermaid())
+	case "json", "yaml":
+		return o.print(os.Stdout, g, nil)
+	default:
+		return fmt.Errorf("unknown graph format %q", o.output)
+	}
+	return nil
+}
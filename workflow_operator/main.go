@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/penk110/k8s_operator/k8s_flow"
+	"github.com/penk110/k8s_operator/k8s_flow/controller"
+)
+
+// 先 kubectl apply -f k8s_flow/controller/crd.yaml 安装 Workflow CRD，也可以用 k8sflow operator 运行
+
+var (
+	namespace   string
+	workers     int
+	gracePeriod time.Duration
+	historyDir  string
+)
+
+func main() {
+	flag.StringVar(&namespace, "n", "", "namespace to watch, all namespaces if empty")
+	flag.IntVar(&workers, "workers", 2, "number of concurrent reconcile workers")
+	flag.DurationVar(&gracePeriod, "grace-period", k8s_flow.DefaultGracePeriod, "how long to wait for running tasks to stop on shutdown")
+	flag.StringVar(&historyDir, "history", "", "directory of the run history, e.g. a mounted volume, disabled if empty")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	c := controller.New(namespace)
+	c.GracePeriod = gracePeriod
+	if historyDir != "" {
+		history, err := k8s_flow.NewFileHistory(historyDir)
+		if err != nil {
+			klog.Fatalf("open run history err: %v", err)
+		}
+		c.History = history
+	}
+	if err := c.Run(ctx, workers); err != nil {
+		klog.Fatalf("workflow controller err: %v", err)
+	}
+}